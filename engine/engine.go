@@ -0,0 +1,168 @@
+// Package engine runs gogen inside another Go program.  Each Engine is built from its own config and
+// writes to its own destination without reading environment variables, the config directory or the
+// global config, so any number of engines can run independently in one process.
+//
+// Templates are registered by name for the whole process, so engines running at the same time should
+// not define different templates with the same name.  The kbps rater depends on gogen's output
+// statistics, which engines don't collect, so it rates like the default rater.
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/coccyx/gogen/generator"
+	config "github.com/coccyx/gogen/internal"
+	"github.com/coccyx/gogen/outputter"
+	"github.com/coccyx/gogen/timer"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is a full gogen configuration, with the same structure as a YAML config file
+type Config = config.Config
+
+// Global holds options which apply to every sample in a Config
+type Global = config.Global
+
+// Output configures how events are formatted
+type Output = config.Output
+
+// Sample describes a set of events to generate
+type Sample = config.Sample
+
+// Token describes a replacement to make in a Sample's lines
+type Token = config.Token
+
+// RaterConfig configures a rater
+type RaterConfig = config.RaterConfig
+
+// GeneratorConfig configures a Lua generator
+type GeneratorConfig = config.GeneratorConfig
+
+// Template formats events with a header, row and footer
+type Template = config.Template
+
+// Engine generates events from one config.  An Engine runs once, and is not safe for concurrent use.
+type Engine struct {
+	c   *config.Config
+	ran bool
+}
+
+// New builds an Engine from c, setting defaults and validating it as if it had been read from a config
+// file.  Invalid samples are disabled and logged, and it is an error if none are left.  The Engine takes
+// ownership of c.
+func New(c *Config) (*Engine, error) {
+	if c == nil {
+		return nil, errors.New("config is nil")
+	}
+	built := config.BuildConfig(config.ConfigConfig{Config: c})
+	if len(built.Samples) == 0 {
+		return nil, errors.New("no valid samples in config")
+	}
+	return &Engine{c: built}, nil
+}
+
+// NewFromYAML builds an Engine from a YAML or JSON config
+func NewFromYAML(b []byte) (*Engine, error) {
+	c := new(Config)
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	return New(c)
+}
+
+// Config returns the Engine's validated config
+func (e *Engine) Config() *Config {
+	return e.c
+}
+
+// Run generates events until every sample is done or ctx is cancelled, writing them to w formatted by the
+// output template.  Each batch of events is written with a single call to w.Write.  Run returns ctx's
+// error if it was cancelled.
+func (e *Engine) Run(ctx context.Context, w io.Writer) error {
+	var buf bytes.Buffer
+	return e.run(ctx, func(ctx context.Context, item *config.OutQueueItem) error {
+		buf.Reset()
+		outputter.Render(item, &buf)
+		_, err := w.Write(buf.Bytes())
+		return err
+	})
+}
+
+// RunChan generates events until every sample is done or ctx is cancelled, sending each event to ch as a
+// map of field names to values.  ch is closed when RunChan returns.  RunChan returns ctx's error if it
+// was cancelled.
+func (e *Engine) RunChan(ctx context.Context, ch chan<- map[string]string) error {
+	defer close(ch)
+	return e.run(ctx, func(ctx context.Context, item *config.OutQueueItem) error {
+		for _, event := range item.Events {
+			select {
+			case ch <- event:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+}
+
+// run starts a timer for every sample, generator workers and a single output worker calling send, so
+// the destination only sees one batch at a time.  Once the timers are done, or the run is cancelled or
+// send fails, the queues are drained and closed.
+func (e *Engine) run(parent context.Context, send func(ctx context.Context, item *config.OutQueueItem) error) error {
+	if e.ran {
+		return errors.New("engine has already run")
+	}
+	e.ran = true
+	c := e.c
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	gq := make(chan *config.GenQueueItem, c.Global.GeneratorQueueLength)
+	oq := make(chan *config.OutQueueItem, c.Global.OutputQueueLength)
+	timerdone := make(chan int)
+	for _, s := range c.Samples {
+		t := &timer.Timer{S: s, GQ: gq, OQ: oq, Done: timerdone}
+		go t.NewTimer(ctx, c.Global.CacheIntervals)
+	}
+
+	gqs := make(chan int)
+	for i := 0; i < c.Global.GeneratorWorkers; i++ {
+		go generator.Start(ctx, gq, gqs)
+	}
+
+	outdone := make(chan error, 1)
+	go func() {
+		var err error
+		for item := range oq {
+			// Keep draining after a failure so generators aren't blocked.  Batches can be empty, for
+			// example when a count is rated to 0, and aren't sent.
+			if err == nil && ctx.Err() == nil && len(item.Events) > 0 {
+				if err = send(ctx, item); err != nil {
+					cancel()
+				}
+			}
+		}
+		outdone <- err
+	}()
+
+	// Timers return promptly once ctx is cancelled
+	for range c.Samples {
+		<-timerdone
+	}
+	close(gq)
+	for i := 0; i < c.Global.GeneratorWorkers; i++ {
+		<-gqs
+	}
+	close(oq)
+
+	err := <-outdone
+	c.CloseAnomalyLabels()
+	c.CloseSequences()
+	if err == nil || parent.Err() != nil {
+		err = parent.Err()
+	}
+	return err
+}
@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+global:
+  output:
+    outputTemplate: raw
+samples:
+  - name: fixture
+    begin: "2001-10-20 12:00:00"
+    end: "2001-10-20 12:00:03"
+    interval: 1
+    count: 2
+    tokens:
+    - name: ts
+      format: template
+      type: timestamp
+      replacement: "%Y-%m-%dT%H:%M:%S"
+    lines:
+    - _raw: $ts$ %s
+`
+
+func TestRunYAML(t *testing.T) {
+	e, err := NewFromYAML([]byte(strings.ReplaceAll(testConfig, "%s", "hello")))
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	assert.NoError(t, e.Run(context.Background(), &out))
+	assert.Equal(t, `2001-10-20T12:00:00 hello
+2001-10-20T12:00:00 hello
+2001-10-20T12:00:01 hello
+2001-10-20T12:00:01 hello
+2001-10-20T12:00:02 hello
+2001-10-20T12:00:02 hello
+`, out.String())
+	assert.Error(t, e.Run(context.Background(), &out))
+}
+
+func TestRunStruct(t *testing.T) {
+	c := &Config{
+		Global: Global{Output: Output{OutputTemplate: "json"}},
+		Samples: []*Sample{{
+			Name:         "struct",
+			EndIntervals: 1,
+			Count:        1,
+			Lines:        []map[string]string{{"_raw": "from a struct", "host": "web-01"}},
+		}},
+	}
+	e, err := New(c)
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	assert.NoError(t, e.Run(context.Background(), &out))
+	assert.Equal(t, `{"_raw":"from a struct","host":"web-01"}`+"\n", out.String())
+}
+
+func TestRunEmptyBatches(t *testing.T) {
+	// The outage leaves the second interval with no events, which templates can't render a header for
+	e, err := NewFromYAML([]byte(strings.ReplaceAll(testConfig, "%s", "hello") + `
+anomalies:
+  - name: down
+    type: outage
+    begin: "2001-10-20 12:00:01"
+    duration: 1s
+`))
+	assert.NoError(t, err)
+	e.Config().Global.Output.OutputTemplate = "csv"
+	var out bytes.Buffer
+	assert.NoError(t, e.Run(context.Background(), &out))
+	assert.NotContains(t, out.String(), "12:00:01")
+	assert.Contains(t, out.String(), "2001-10-20T12:00:02 hello")
+}
+
+func TestIndependentEngines(t *testing.T) {
+	var wg sync.WaitGroup
+	outs := make([]bytes.Buffer, 4)
+	for i := range outs {
+		e, err := NewFromYAML([]byte(strings.ReplaceAll(testConfig, "%s", strings.Repeat("x", i+1))))
+		assert.NoError(t, err)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, e.Run(context.Background(), &outs[i]))
+		}(i)
+	}
+	wg.Wait()
+	for i := range outs {
+		lines := strings.Split(strings.TrimSpace(outs[i].String()), "\n")
+		assert.Len(t, lines, 6)
+		for _, l := range lines {
+			assert.True(t, strings.HasSuffix(l, " "+strings.Repeat("x", i+1)), l)
+		}
+	}
+}
+
+func TestRunChanCancel(t *testing.T) {
+	e, err := NewFromYAML([]byte(`
+samples:
+  - name: realtime
+    interval: 1
+    count: 5
+    lines:
+    - _raw: forever
+`))
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan map[string]string)
+	errc := make(chan error)
+	go func() {
+		errc <- e.RunChan(ctx, ch)
+	}()
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "forever", (<-ch)["_raw"])
+	}
+	cancel()
+	for range ch {
+	}
+	assert.ErrorIs(t, <-errc, context.Canceled)
+}
+
+func TestNoValidSamples(t *testing.T) {
+	_, err := NewFromYAML([]byte(`
+samples:
+  - name: nolines
+`))
+	assert.Error(t, err)
+	_, err = NewFromYAML([]byte("samples: ["))
+	assert.Error(t, err)
+	_, err = New(nil)
+	assert.Error(t, err)
+}
+
+func TestRunStructRater(t *testing.T) {
+	c := &Config{
+		Global: Global{Output: Output{OutputTemplate: "raw"}},
+		Raters: []*RaterConfig{{
+			Name: "noon",
+			Type: "config",
+			Options: map[string]interface{}{
+				"HourOfDay": map[int]float64{12: 3},
+				"DayOfWeek": map[int]int{6: 1},
+			},
+		}},
+		Samples: []*Sample{{
+			Name:        "struct",
+			Begin:       "2001-10-20 12:00:00",
+			End:         "2001-10-20 12:00:01",
+			Interval:    1,
+			Count:       1,
+			RaterString: "noon",
+			Lines:       []map[string]string{{"_raw": "rated"}},
+		}},
+	}
+	e, err := New(c)
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	assert.NoError(t, e.Run(context.Background(), &out))
+	assert.Equal(t, "rated\nrated\nrated\n", out.String())
+}
@@ -0,0 +1,27 @@
+package generator
+
+import (
+	config "github.com/coccyx/gogen/internal"
+)
+
+// genAnomalies labels the item's events with active distribution anomalies, and sends the events of active
+// burst anomalies, generated from the anomaly's lines with the sample's tokens
+func genAnomalies(item *config.GenQueueItem) {
+	s := item.S
+	for _, a := range s.ActiveAnomalies(item.Now, "distribution") {
+		s.LabelAnomaly(a, item.Now, item.Count)
+	}
+	for _, a := range s.ActiveAnomalies(item.Now, "burst") {
+		events := make([]map[string]string, 0, a.Count)
+		for i := 0; i < a.Count; i++ {
+			e := copyevent(a.Lines[item.Rand.Intn(len(a.Lines))])
+			replaceSampleTokens(item, &e, nil)
+			events = append(events, e)
+		}
+		// Bursts are sent on their own so they aren't cached with the sample's events
+		burst := *item
+		burst.Cache = &config.CacheItem{}
+		sendItem(&burst, events)
+		s.LabelAnomaly(a, item.Now, a.Count)
+	}
+}
@@ -1,6 +1,7 @@
 package generator
 
 import (
+	"context"
 	"math/rand"
 	"time"
 
@@ -8,15 +9,36 @@ import (
 	log "github.com/coccyx/gogen/logger"
 )
 
-var (
-	cache map[string][]map[string]string
-)
+// GenQueueItem is one generation job for a Generator
+type GenQueueItem = config.GenQueueItem
+
+// Generator generates the events for a GenQueueItem and hands them to Send
+type Generator = config.Generator
+
+// Factory creates a Generator.  Each generator worker calls it once for every sample using the generator.
+type Factory = config.GeneratorFactory
+
+// RegisterGenerator makes a native Go generator available to samples as `generator: name`.  Generators
+// defined in the config with a Lua script take precedence over registered generators of the same name.
+// It panics if name is a builtin or already registered, so it is intended to be called from init.
+func RegisterGenerator(name string, factory Factory) {
+	if name == "sample" || name == "replay" || name == "scenario" {
+		panic("gogen: RegisterGenerator cannot replace builtin generator " + name)
+	}
+	config.RegisterGenerator(name, factory)
+}
 
-func Start(gq chan *config.GenQueueItem, gqs chan int) {
+// Send hands the events generated for item to the output queue
+func Send(item *GenQueueItem, events []map[string]string) {
+	sendItem(item, events)
+}
+
+// Start starts a generator worker, which generates items from gq until it's closed and then signals gqs.
+// Once ctx is cancelled, items still in the queue are skipped rather than generated.
+func Start(ctx context.Context, gq chan *config.GenQueueItem, gqs chan int) {
 	source := rand.NewSource(time.Now().UnixNano())
 	generator := rand.New(source)
 	gens := make(map[string]config.Generator)
-	cache = make(map[string][]map[string]string)
 	// defer profile.Start(profile.CPUProfile, profile.ProfilePath(".")).Stop()
 	// defer profile.Start(profile.MemProfile, profile.ProfilePath(".")).Stop()
 	for {
@@ -25,13 +47,25 @@ func Start(gq chan *config.GenQueueItem, gqs chan int) {
 			gqs <- 1
 			break
 		}
-		item.Rand = generator
+		if ctx.Err() != nil {
+			continue
+		}
+		if item.Rand == nil {
+			item.Rand = generator
+		}
+		if item.Ctx == nil {
+			item.Ctx = ctx
+		}
 		// Check to see if our generator is not set
 		if gens[item.S.Name] == nil {
 			log.Infof("Setting sample '%s' to generator '%s'", item.S.Name, item.S.Generator)
 			if item.S.Generator == "sample" || item.S.Generator == "replay" {
 				s := new(sample)
 				gens[item.S.Name] = s
+			} else if item.S.Generator == "scenario" {
+				gens[item.S.Name] = new(scenario)
+			} else if factory := config.FindGeneratorFactory(item.S.Generator); item.S.CustomGenerator == nil && factory != nil {
+				gens[item.S.Name] = factory()
 			} else {
 				s := new(luagen)
 				gens[item.S.Name] = s
@@ -41,9 +75,7 @@ func Start(gq chan *config.GenQueueItem, gqs chan int) {
 		useCache := false
 		var cachedEvents []map[string]string
 		if item.Cache.UseCache {
-			item.Cache.RLock()
-			cachedEvents, useCache = cache[item.S.Name]
-			item.Cache.RUnlock()
+			cachedEvents, useCache = item.Cache.Events.Get()
 		}
 		if useCache {
 			sendItem(item, cachedEvents)
@@ -53,17 +85,16 @@ func Start(gq chan *config.GenQueueItem, gqs chan int) {
 			if err != nil {
 				log.Errorf("Error received from generator: %s", err)
 			}
+			genAnomalies(item)
 		}
 		// log.Debugf("Finished generating item %#v", item)
 	}
 }
 
 func sendItem(item *config.GenQueueItem, events []map[string]string) {
-	outitem := &config.OutQueueItem{S: item.S, Events: events, Cache: item.Cache}
+	outitem := &config.OutQueueItem{S: item.S, Events: events, Rand: item.Rand, Cache: item.Cache, Ctx: item.Ctx}
 	if item.Cache.SetCache {
-		item.Cache.Lock()
-		cache[item.S.Name] = events
-		item.Cache.Unlock()
+		item.Cache.Events.Set(events)
 	}
 	item.OQ <- outitem
 }
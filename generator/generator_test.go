@@ -1,9 +1,15 @@
 package generator
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -39,7 +45,7 @@ func TestGenerator(t *testing.T) {
 	gqi := &config.GenQueueItem{Count: 1, Earliest: now(), Latest: now(), Now: now(), S: s, OQ: oq, Rand: randgen, Cache: &config.CacheItem{UseCache: false, SetCache: false}}
 	gq := make(chan *config.GenQueueItem)
 	gqs := make(chan int)
-	go Start(gq, gqs)
+	go Start(context.Background(), gq, gqs)
 	gq <- gqi
 	close(gq)
 	oqi := <-oq
@@ -146,7 +152,7 @@ func TestGeneratorStartWorker(t *testing.T) {
 
 	gq := make(chan *config.GenQueueItem)
 	gqs := make(chan int)
-	go Start(gq, gqs)
+	go Start(context.Background(), gq, gqs)
 
 	// Send multiple items to test the "generator already set" path
 	for i := 0; i < 3; i++ {
@@ -216,25 +222,117 @@ func TestGeneratorCache(t *testing.T) {
 	if s == nil {
 		t.Fatalf("Sample token-static not found in file: %s", home)
 	}
-	gqi := &config.GenQueueItem{Count: 1, Earliest: now(), Latest: now(), Now: now(), S: s, OQ: oq, Rand: randgen, Cache: &config.CacheItem{UseCache: false, SetCache: true}}
+	events := new(config.EventCache)
+	gqi := &config.GenQueueItem{Count: 1, Earliest: now(), Latest: now(), Now: now(), S: s, OQ: oq, Rand: randgen, Cache: &config.CacheItem{UseCache: false, SetCache: true, Events: events}}
 	gq := make(chan *config.GenQueueItem)
 	gqs := make(chan int)
-	go Start(gq, gqs)
+	go Start(context.Background(), gq, gqs)
 	gq <- gqi
 	oqi := <-oq
 	assert.Equal(t, "foo", oqi.Events[0]["_raw"])
 
 	// Change token replacement, validate it's different without cache
 	s.Tokens[0].Replacement = "foo2"
-	gqi = &config.GenQueueItem{Count: 1, Earliest: now(), Latest: now(), Now: now(), S: s, OQ: oq, Rand: randgen, Cache: &config.CacheItem{UseCache: false, SetCache: false}}
+	gqi = &config.GenQueueItem{Count: 1, Earliest: now(), Latest: now(), Now: now(), S: s, OQ: oq, Rand: randgen, Cache: &config.CacheItem{UseCache: false, SetCache: false, Events: events}}
 	gq <- gqi
 	oqi = <-oq
 	assert.Equal(t, "foo2", oqi.Events[0]["_raw"])
 
 	// Now use cache, should be same as the old
-	gqi = &config.GenQueueItem{Count: 1, Earliest: now(), Latest: now(), Now: now(), S: s, OQ: oq, Rand: randgen, Cache: &config.CacheItem{UseCache: true, SetCache: false}}
+	gqi = &config.GenQueueItem{Count: 1, Earliest: now(), Latest: now(), Now: now(), S: s, OQ: oq, Rand: randgen, Cache: &config.CacheItem{UseCache: true, SetCache: false, Events: events}}
 	gq <- gqi
 	close(gq)
 	oqi = <-oq
 	assert.Equal(t, "foo", oqi.Events[0]["_raw"])
 }
+
+func TestGeneratorExpression(t *testing.T) {
+	home := filepath.Join("..", "tests", "expression")
+	now, randgen := setupGenTest(t, home, 0)
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join(home, "expression.yml"))
+	defer os.Unsetenv("GOGEN_FULLCONFIG")
+
+	c := config.NewConfig()
+	s := c.FindSampleByName("expression")
+	if s == nil {
+		t.Fatalf("Sample expression not found")
+	}
+	assert.True(t, s.SinglePass)
+
+	re := regexp.MustCompile(`kb=(\S+) bytes=(\d+)|user=(\w+) hash=(\w+) class=(\w+)`)
+	for _, singlePass := range []bool{true, false} {
+		s.SinglePass = singlePass
+		oq := make(chan *config.OutQueueItem)
+		gqi := &config.GenQueueItem{Count: 10, Earliest: now(), Latest: now(), Now: now(), S: s, OQ: oq, Rand: randgen, Cache: &config.CacheItem{}}
+		go func() {
+			if singlePass {
+				genSinglePass(gqi)
+			} else {
+				genMultiPass(gqi)
+			}
+		}()
+
+		oqi := <-oq
+		assert.Len(t, oqi.Events, 10)
+		for _, e := range oqi.Events {
+			for _, m := range re.FindAllStringSubmatch(e["_raw"], -1) {
+				if m[1] != "" {
+					b, _ := strconv.Atoi(m[2])
+					assert.Equal(t, strconv.FormatFloat(float64(b)/1024, 'f', 1, 64), m[1], e["_raw"])
+				} else {
+					sum := md5.Sum([]byte(strings.ToLower(m[3])))
+					assert.Equal(t, hex.EncodeToString(sum[:]), m[4], e["_raw"])
+					if e["status"] == "404" {
+						assert.Equal(t, "client_error", m[5])
+					} else {
+						assert.Equal(t, "server_error", m[5])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestGeneratorExpressionRepeated(t *testing.T) {
+	home := filepath.Join("..", "tests", "expression")
+	now, randgen := setupGenTest(t, home, 0)
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join(home, "expression.yml"))
+	defer os.Unsetenv("GOGEN_FULLCONFIG")
+
+	c := config.NewConfig()
+	s := c.FindSampleByName("repeated")
+	if s == nil {
+		t.Fatalf("Sample repeated not found")
+	}
+
+	// Tokens expressions don't reference still get a value for each occurrence
+	re := regexp.MustCompile(`^id=(\d+) id=(\d+) bytes=(\d+) double=(\d+)$`)
+	for _, singlePass := range []bool{true, false} {
+		s.SinglePass = singlePass
+		oq := make(chan *config.OutQueueItem)
+		gqi := &config.GenQueueItem{Count: 10, Earliest: now(), Latest: now(), Now: now(), S: s, OQ: oq, Rand: randgen, Cache: &config.CacheItem{}}
+		go func() {
+			if singlePass {
+				genSinglePass(gqi)
+			} else {
+				genMultiPass(gqi)
+			}
+		}()
+
+		oqi := <-oq
+		assert.Len(t, oqi.Events, 10)
+		distinct := 0
+		for _, e := range oqi.Events {
+			m := re.FindStringSubmatch(e["_raw"])
+			if !assert.NotNil(t, m, e["_raw"]) {
+				continue
+			}
+			if m[1] != m[2] && m[1] != e["host"] {
+				distinct++
+			}
+			b, _ := strconv.Atoi(m[3])
+			assert.Equal(t, strconv.Itoa(b*2), m[4], e["_raw"])
+		}
+		assert.Equal(t, 10, distinct)
+	}
+}
@@ -23,7 +23,17 @@ type luagen struct {
 
 func sleep(L *lua.LState) int {
 	lv := L.ToInt64(1)
-	time.Sleep(time.Duration(lv))
+	ctx := L.Context()
+	if ctx == nil {
+		time.Sleep(time.Duration(lv))
+		return 0
+	}
+	timer := time.NewTimer(time.Duration(lv))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
 	return 0
 }
 
@@ -397,7 +407,7 @@ func (lg *luagen) replaceTokens(L *lua.LState) int {
 	}
 
 	// Replace configured tokens
-	replaceTokens(item, &event, &choices, item.S.Tokens)
+	replaceSampleTokens(item, &event, &choices)
 
 	// Replace any tokens submitted through setTokens
 	if len(lg.tokens) > 0 && !replaceFirst {
@@ -484,6 +494,7 @@ func (lg *luagen) Gen(item *config.GenQueueItem) error {
 	L.SetGlobal("earliest", lua.LNumber(float64(item.Earliest.UnixNano())/float64(time.Second)))
 	L.SetGlobal("latest", lua.LNumber(float64(item.Latest.UnixNano())/float64(time.Second)))
 	L.SetGlobal("now", lua.LNumber(float64(item.Now.UnixNano())/float64(time.Second)))
+	config.SetLuaRand(L, item.Rand)
 
 	// log.Debugf("Calling DoString for %# v", s.CustomGenerator.Script)
 	var f *lua.LFunction
@@ -506,9 +517,17 @@ func (lg *luagen) Gen(item *config.GenQueueItem) error {
 			Upvalues:  make([]*lua.Upvalue, 0),
 		}
 	}
+	// Scripts stop, and sleep returns, when the run is cancelled
+	if item.Ctx != nil {
+		L.SetContext(item.Ctx)
+		defer L.RemoveContext()
+	}
 	// Push our function onto the stack and call it with no arguments
 	L.Push(f)
 	err := L.PCall(0, lua.MultRet, nil)
+	if err != nil && item.Ctx != nil && item.Ctx.Err() != nil {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("Error executing script for generator '%s': %s", s.CustomGenerator.Name, err)
 	}
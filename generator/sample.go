@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"math"
 	"sync"
+	"time"
 
 	config "github.com/coccyx/gogen/internal"
 	log "github.com/coccyx/gogen/logger"
@@ -34,6 +35,13 @@ func (foo sample) Gen(item *config.GenQueueItem) error {
 	// log.Debugf("Generating sample '%s' with count %d, et: '%s', lt: '%s', SinglePass: %v", s.Name, item.Count, item.Earliest, item.Latest, s.SinglePass)
 	// startTime := time.Now()
 
+	if item.Line != nil {
+		// Lines streamed from a replay file aren't broken up ahead of time, so replace tokens on the line directly
+		e := copyevent(item.Line)
+		replaceSampleTokens(item, &e, nil)
+		sendItem(item, []map[string]string{e})
+		return nil
+	}
 	if s.SinglePass {
 		return genSinglePass(item)
 	}
@@ -46,6 +54,7 @@ func genSinglePass(item *config.GenQueueItem) error {
 
 	if slen > 0 {
 		events := make([]map[string]string, 0, item.Count)
+		times := spreadTimes(item)
 		if s.Generator == "replay" {
 			events = append(events, getBrokenEvent(item, item.Event))
 		} else {
@@ -53,13 +62,13 @@ func genSinglePass(item *config.GenQueueItem) error {
 				// log.Debugf("Random filling events for sample '%s' with %d events", s.Name, item.Count)
 
 				for i := 0; i < item.Count; i++ {
-					events = append(events, getBrokenEvent(item, item.Rand.Intn(slen)))
+					events = append(events, getBrokenEvent(eventItem(item, times, i), item.Rand.Intn(slen)))
 				}
 			} else {
 				if item.Count <= slen {
 					for i := 0; i < item.Count; i++ {
 						// log.Debugf("Count <= sample len, filling with sample '%s' for %d events", s.Name, item.Count)
-						events = append(events, getBrokenEvent(item, i))
+						events = append(events, getBrokenEvent(eventItem(item, times, i), i))
 					}
 				} else {
 					iters := int(math.Ceil(float64(item.Count) / float64(slen)))
@@ -75,7 +84,7 @@ func genSinglePass(item *config.GenQueueItem) error {
 						// log.Debugf("Appending %d events from lines, length %d", count, slen)
 						// end := (i * slen) + count
 						for j := 0; j < count; j++ {
-							events = append(events, getBrokenEvent(item, j))
+							events = append(events, getBrokenEvent(eventItem(item, times, len(events)), j))
 						}
 					}
 				}
@@ -90,31 +99,9 @@ func getBrokenEvent(item *config.GenQueueItem, i int) map[string]string {
 	s := item.S
 	ret := make(map[string]string, len(s.BrokenLines[i]))
 	choices := make(map[int]int)
-	genSection := func(k string, v []config.StringOrToken, ret map[string]string, choices map[int]int) {
-		event := bp.Get().(*bytes.Buffer)
-		event.Reset()
-		for _, st := range v {
-			if st.T == nil {
-				event.WriteString(st.S)
-			} else {
-				var choice int
-				if _, ok := choices[st.T.Group]; ok {
-					choice = choices[st.T.Group]
-				} else {
-					choice = -1
-				}
-				replacement, choice, err := st.T.GenReplacement(choice, item.Earliest, item.Latest, item.Now, item.Rand, ret)
-				if err != nil {
-					log.Errorf("Error generating replacement for token '%s' in sample '%s'", st.T.Name, s.Name)
-				}
-				event.WriteString(replacement)
-				if st.T.Group > 0 {
-					choices[st.T.Group] = choice
-				}
-			}
-		}
-		ret[k] = event.String()
-		bp.Put(event)
+	var values map[string]string
+	if s.TokenOrder != nil {
+		values = genTokenValues(item, s.Lines[i], choices)
 	}
 	// Generate _channel token last
 	channelFound := false
@@ -123,14 +110,96 @@ func getBrokenEvent(item *config.GenQueueItem, i int) map[string]string {
 			channelFound = true
 			continue
 		}
-		genSection(k, v, ret, choices)
+		ret[k] = genSection(item, v, ret, choices, values)
 	}
 	if channelFound {
-		genSection("_channel", s.BrokenLines[i]["_channel"], ret, choices)
+		ret["_channel"] = genSection(item, s.BrokenLines[i]["_channel"], ret, choices, values)
+	}
+	return ret
+}
+
+// getPlannedEvent builds line i of a sample which isn't SinglePass from its precompiled LinePlan
+func getPlannedEvent(item *config.GenQueueItem, i int) map[string]string {
+	s := item.S
+	line := s.Lines[i]
+	plan := s.LinePlans[i]
+	// Like multipass, tokens see the whole event, with the fields generated so far replaced
+	ret := copyevent(line)
+	choices := make(map[int]int)
+	var values map[string]string
+	if s.TokenOrder != nil {
+		values = genTokenValues(item, line, choices)
+	}
+	for _, k := range plan.Fields {
+		if v, ok := plan.Broken[k]; ok {
+			ret[k] = genSection(item, v, ret, choices, values)
+		} else {
+			ret[k] = replaceField(item, line, k, plan.Dynamic[k], ret, choices, values)
+		}
 	}
 	return ret
 }
 
+// genSection builds a field from its strings and tokens into a pooled buffer
+func genSection(item *config.GenQueueItem, v []config.StringOrToken, ret map[string]string, choices map[int]int, values map[string]string) string {
+	event := bp.Get().(*bytes.Buffer)
+	event.Reset()
+	for _, st := range v {
+		if st.T == nil {
+			event.WriteString(st.S)
+		} else if val, ok := values[st.T.Name]; ok {
+			event.WriteString(val)
+		} else {
+			var choice int
+			if _, ok := choices[st.T.Group]; ok {
+				choice = choices[st.T.Group]
+			} else {
+				choice = -1
+			}
+			replacement, choice, err := st.T.GenReplacement(choice, item.Earliest, item.Latest, item.Now, item.Rand, ret)
+			if err != nil {
+				log.Errorf("Error generating replacement for token '%s' in sample '%s'", st.T.Name, item.S.Name)
+			}
+			event.WriteString(replacement)
+			if st.T.Group > 0 {
+				choices[st.T.Group] = choice
+			}
+		}
+	}
+	out := event.String()
+	bp.Put(event)
+	return out
+}
+
+// replaceField replaces tokens one after another in a field where they overlap, the same as multipass
+func replaceField(item *config.GenQueueItem, line map[string]string, field string, tokens []*config.Token, ret map[string]string, choices map[int]int, values map[string]string) string {
+	fieldval, found := line[field]
+	for _, token := range tokens {
+		if !found && token.Format == "template" {
+			fieldval = token.Token
+		}
+		found = true
+		if val, ok := values[token.Name]; ok {
+			token.ReplaceValue(&fieldval, val)
+			continue
+		}
+		var choice int
+		var err error
+		if _, ok := choices[token.Group]; ok {
+			choice = choices[token.Group]
+		} else {
+			choice = -1
+		}
+		if choice, err = token.Replace(&fieldval, choice, item.Earliest, item.Latest, item.Now, item.Rand, ret); err != nil {
+			log.Error(err)
+		}
+		if token.Group > 0 {
+			choices[token.Group] = choice
+		}
+	}
+	return fieldval
+}
+
 func genMultiPass(item *config.GenQueueItem) error {
 	s := item.S
 	slen := len(s.Lines)
@@ -138,19 +207,20 @@ func genMultiPass(item *config.GenQueueItem) error {
 	if slen > 0 {
 		var events []map[string]string
 		events = make([]map[string]string, 0, item.Count)
+		times := spreadTimes(item)
 		if s.Generator == "replay" {
-			events = append(events, copyevent(s.Lines[item.Event]))
+			events = append(events, getMultiPassEvent(item, item.Event))
 		} else {
 			if s.RandomizeEvents {
 				// log.Debugf("Random filling events for sample '%s' with %d events", s.Name, item.Count)
 
 				for i := 0; i < item.Count; i++ {
-					events = append(events, copyevent(s.Lines[item.Rand.Intn(slen)]))
+					events = append(events, getMultiPassEvent(eventItem(item, times, i), item.Rand.Intn(slen)))
 				}
 			} else {
 				if item.Count <= slen {
 					for i := 0; i < item.Count; i++ {
-						events = append(events, copyevent(s.Lines[i]))
+						events = append(events, getMultiPassEvent(eventItem(item, times, i), i))
 					}
 				} else {
 					iters := int(math.Ceil(float64(item.Count) / float64(slen)))
@@ -166,7 +236,7 @@ func genMultiPass(item *config.GenQueueItem) error {
 						// log.Debugf("Appending %d events from lines, length %d", count, slen)
 						// end := (i * slen) + count
 						for j := 0; j < count; j++ {
-							events = append(events, copyevent(s.Lines[j]))
+							events = append(events, getMultiPassEvent(eventItem(item, times, len(events)), j))
 						}
 					}
 				}
@@ -175,14 +245,61 @@ func genMultiPass(item *config.GenQueueItem) error {
 
 		// log.Debugf("Events: %#v", events)
 
-		for i := 0; i < item.Count; i++ {
-			replaceTokens(item, &events[i], nil, item.S.Tokens)
-		}
 		sendItem(item, events)
 	}
 	return nil
 }
 
+// spreadTimes returns sorted instants between Earliest and Latest, one for each event of the item, for samples with SpreadTime set.
+// even spaces them equally, and poisson places them as arrivals of a Poisson process conditioned on the count, using normalized exponential gaps.
+func spreadTimes(item *config.GenQueueItem) []time.Time {
+	s := item.S
+	if s.SpreadTime == "" || s.Generator == "replay" || item.Count <= 0 {
+		return nil
+	}
+	td := float64(item.Latest.Sub(item.Earliest))
+	times := make([]time.Time, item.Count)
+	switch s.SpreadTime {
+	case "even":
+		for i := range times {
+			times[i] = item.Earliest.Add(time.Duration(td * float64(i) / float64(item.Count)))
+		}
+	case "poisson":
+		gaps := make([]float64, item.Count+1)
+		total := 0.0
+		for i := range gaps {
+			total += item.Rand.ExpFloat64()
+			gaps[i] = total
+		}
+		for i := range times {
+			times[i] = item.Earliest.Add(time.Duration(td * gaps[i] / total))
+		}
+	}
+	return times
+}
+
+// eventItem returns the item to generate the i'th event of a batch with.  With spread times, every event
+// gets its own instant, so Earliest and Latest are both set to it.
+func eventItem(item *config.GenQueueItem, times []time.Time, i int) *config.GenQueueItem {
+	if times == nil {
+		return item
+	}
+	ei := *item
+	ei.Earliest = times[i]
+	ei.Latest = times[i]
+	return &ei
+}
+
+// getMultiPassEvent builds line i from its LinePlan, or if there is no plan, copies the line and replaces tokens one at a time
+func getMultiPassEvent(item *config.GenQueueItem, i int) map[string]string {
+	if item.S.LinePlans == nil {
+		e := copyevent(item.S.Lines[i])
+		replaceSampleTokens(item, &e, nil)
+		return e
+	}
+	return getPlannedEvent(item, i)
+}
+
 func replaceTokens(item *config.GenQueueItem, event *map[string]string, outsidechoices *map[int]int, tokens []config.Token) {
 	var choices map[int]int
 	if outsidechoices == nil {
@@ -220,6 +337,74 @@ func replaceTokens(item *config.GenQueueItem, event *map[string]string, outsidec
 	}
 }
 
+// replaceSampleTokens replaces the sample's configured tokens in event.  For samples with expression tokens,
+// every token is generated once up front in dependency order so expressions can reference their values.
+func replaceSampleTokens(item *config.GenQueueItem, event *map[string]string, outsidechoices *map[int]int) {
+	if item.S.TokenOrder == nil {
+		replaceTokens(item, event, outsidechoices, item.S.Tokens)
+		return
+	}
+	var choices map[int]int
+	if outsidechoices == nil {
+		choices = make(map[int]int)
+	} else {
+		choices = *outsidechoices
+	}
+	e := *event
+	values := genTokenValues(item, e, choices)
+	for _, token := range item.S.Tokens {
+		if !token.Disabled {
+			v, ok := values[token.Name]
+			if !ok {
+				replaceTokens(item, event, &choices, []config.Token{token})
+				continue
+			}
+			var fieldval string
+			if fieldval, ok = e[token.Field]; !ok {
+				if token.Format == "template" {
+					fieldval = token.Token
+				}
+			}
+			token.ReplaceValue(&fieldval, v)
+			e[token.Field] = fieldval
+		}
+	}
+}
+
+// genTokenValues generates one value for each expression token of the sample and each token they reference, in
+// TokenOrder, evaluating expressions against the values generated so far and the event's fields.  Other tokens are
+// left to replacement, so each of their occurrences gets its own value.  _channel depends on the final event and is
+// also left to replacement.
+func genTokenValues(item *config.GenQueueItem, fields map[string]string, choices map[int]int) map[string]string {
+	s := item.S
+	values := make(map[string]string, len(s.TokenOrder))
+	for _, i := range s.TokenOrder {
+		t := &s.Tokens[i]
+		if t.Disabled || !t.ExprValue || t.Type == "_channel" {
+			continue
+		}
+		var replacement string
+		var err error
+		if t.Type == "expression" {
+			replacement, err = t.Evaluate(values, fields)
+		} else {
+			choice := -1
+			if c, ok := choices[t.Group]; ok {
+				choice = c
+			}
+			replacement, choice, err = t.GenReplacement(choice, item.Earliest, item.Latest, item.Now, item.Rand, fields)
+			if t.Group > 0 {
+				choices[t.Group] = choice
+			}
+		}
+		if err != nil {
+			log.Errorf("Error generating replacement for token '%s' in sample '%s': %s", t.Name, s.Name, err)
+		}
+		values[t.Name] = replacement
+	}
+	return values
+}
+
 func copyevent(src map[string]string) (dst map[string]string) {
 	dst = make(map[string]string, len(src))
 	for k, v := range src {
@@ -4,6 +4,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -47,3 +48,77 @@ func TestSampleGen(t *testing.T) {
 	oqi = <-oq
 	assert.Equal(t, "foo foo bar", oqi.Events[0]["_raw"])
 }
+
+func TestSampleGenLinePlans(t *testing.T) {
+	home := filepath.Join("..", "tests", "singlepass")
+	now, randgen := setupGenTest(t, filepath.Join(home, "partial-tokens.yml"), 0)
+
+	c := config.NewConfig()
+	s := c.FindSampleByName("partial-tokens")
+	if s == nil {
+		t.Fatalf("Sample partial-tokens not found")
+	}
+	assert.NotNil(t, s.LinePlans)
+
+	oq := make(chan *config.OutQueueItem)
+	gqi := &config.GenQueueItem{Count: 2, Earliest: now(), Latest: now(), Now: now(), S: s, OQ: oq, Rand: randgen, Cache: &config.CacheItem{}}
+	go new(sample).Gen(gqi)
+	oqi := <-oq
+	assert.Equal(t, "one=1", oqi.Events[0]["_raw"])
+	assert.Equal(t, "two=2 one=1", oqi.Events[1]["_raw"])
+	for _, e := range oqi.Events {
+		assert.Equal(t, "foo foo bar", e["other"])
+	}
+}
+
+func TestSampleGenChained(t *testing.T) {
+	home := filepath.Join("..", "tests", "singlepass")
+	now, randgen := setupGenTest(t, filepath.Join(home, "chained.yml"), 0)
+
+	c := config.NewConfig()
+	s := c.FindSampleByName("chained")
+	if s == nil {
+		t.Fatalf("Sample chained not found")
+	}
+
+	oq := make(chan *config.OutQueueItem)
+	gqi := &config.GenQueueItem{Count: 1, Earliest: now(), Latest: now(), Now: now(), S: s, OQ: oq, Rand: randgen, Cache: &config.CacheItem{}}
+	go new(sample).Gen(gqi)
+	oqi := <-oq
+	// Tokens match text inserted by earlier tokens, the same as replacing one token after another
+	assert.Equal(t, "hello bob id=7 id=7", oqi.Events[0]["_raw"])
+	assert.Equal(t, "2001-10-20 web01", oqi.Events[0]["other"])
+}
+
+func TestSampleGenSpreadTime(t *testing.T) {
+	home := filepath.Join("..", "tests", "spread")
+	_, randgen := setupGenTest(t, home, 0)
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join(home, "spread.yml"))
+	defer os.Unsetenv("GOGEN_FULLCONFIG")
+
+	c := config.NewConfig()
+	et := time.Date(2001, 10, 20, 12, 0, 0, 0, time.UTC)
+	lt := et.Add(100 * time.Second)
+	for _, name := range []string{"even", "poisson"} {
+		s := c.FindSampleByName(name)
+		if s == nil {
+			t.Fatalf("Sample %s not found", name)
+		}
+		oq := make(chan *config.OutQueueItem)
+		gqi := &config.GenQueueItem{Count: 100, Earliest: et, Latest: lt, Now: lt, S: s, OQ: oq, Rand: randgen, Cache: &config.CacheItem{}}
+		go new(sample).Gen(gqi)
+		oqi := <-oq
+		assert.Len(t, oqi.Events, 100)
+		var last time.Time
+		for i, e := range oqi.Events {
+			ts, err := time.Parse("2006-01-02T15:04:05.000000Z07:00", strings.Fields(e["_raw"])[0])
+			assert.NoError(t, err)
+			assert.False(t, ts.Before(last), "%s event %d out of order", name, i)
+			assert.False(t, ts.Before(et) || ts.After(lt), "%s event %d outside window", name, i)
+			if name == "even" {
+				assert.Equal(t, et.Add(time.Duration(i)*time.Second), ts.UTC())
+			}
+			last = ts
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package generator
+
+import (
+	"time"
+
+	config "github.com/coccyx/gogen/internal"
+	log "github.com/coccyx/gogen/logger"
+)
+
+type scenario struct{}
+
+// Gen starts item.Count new instances of the sample's scenario spread over the interval before item.Now, and
+// then generates the lines of every state the running instances reach before item.Now.  Without an interval,
+// or from the sample's last interval on, every instance is run to the end.
+func (foo scenario) Gen(item *config.GenQueueItem) error {
+	s := item.S
+	sc := s.Scenario
+	interval := time.Duration(s.Interval) * time.Second
+	for i := 0; i < item.Count; i++ {
+		start := item.Now
+		if interval > 0 {
+			start = start.Add(time.Duration(item.Rand.Int63n(int64(interval))) - interval)
+		}
+		sc.StartInstance(start, scenarioVars(item, start))
+	}
+	// In the last interval before the sample's end, run every instance to the end so none are cut off.
+	// Workers may generate earlier intervals after it, so the scenario runs those to the end too.
+	var before time.Time
+	if interval > 0 {
+		before = item.Now
+		if !s.EndParsed.IsZero() && !item.Now.Add(interval).Before(s.EndParsed) {
+			sc.Finish()
+		}
+	}
+	var events []map[string]string
+	for _, se := range sc.Advance(before, item.Rand) {
+		ei := *item
+		ei.Earliest, ei.Latest, ei.Now = se.Time, se.Time, se.Time
+		for _, line := range se.State.Lines {
+			events = append(events, scenarioEvent(&ei, line, se.Vars))
+		}
+	}
+	if len(events) > 0 {
+		sendItem(item, events)
+	}
+	return nil
+}
+
+// scenarioVars generates the values of the scenario's variables for a new instance starting at t
+func scenarioVars(item *config.GenQueueItem, t time.Time) map[string]string {
+	s := item.S
+	vars := make(map[string]string, len(s.Scenario.Vars))
+	for _, name := range s.Scenario.Vars {
+		for _, token := range s.Tokens {
+			if token.Name == name {
+				v, _, err := token.GenReplacement(-1, t, t, t, item.Rand, map[string]string{})
+				if err != nil {
+					log.Errorf("Error generating scenario variable '%s' in sample '%s': %s", name, s.Name, err)
+				}
+				vars[name] = v
+				break
+			}
+		}
+	}
+	return vars
+}
+
+// scenarioEvent copies line and replaces the sample's tokens, using the instance's value for tokens which are
+// scenario variables
+func scenarioEvent(item *config.GenQueueItem, line map[string]string, vars map[string]string) map[string]string {
+	e := copyevent(line)
+	choices := make(map[int]int)
+	for _, token := range item.S.Tokens {
+		v, ok := vars[token.Name]
+		if !ok {
+			replaceTokens(item, &e, &choices, []config.Token{token})
+			continue
+		}
+		fieldval, found := e[token.Field]
+		if !found && token.Format == "template" {
+			fieldval = token.Token
+		}
+		token.ReplaceValue(&fieldval, v)
+		e[token.Field] = fieldval
+	}
+	return e
+}
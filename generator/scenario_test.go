@@ -0,0 +1,37 @@
+package generator
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	config "github.com/coccyx/gogen/internal"
+	"github.com/coccyx/gogen/tests"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScenarioOutOfOrder(t *testing.T) {
+	home := filepath.Join("..", "tests", "scenario")
+	_, randgen := setupGenTest(t, home, 0)
+
+	s := tests.FindSampleInFile(home, "bruteforce")
+	if s == nil {
+		t.Fatalf("Sample bruteforce not found in file: %s", home)
+	}
+	n := time.Date(2001, 10, 20, 12, 0, 0, 0, time.UTC)
+	s.EndParsed = n.Add(2 * time.Minute)
+
+	// The last interval is generated before the one ahead of it, as can happen with several workers
+	oq := make(chan *config.OutQueueItem, 10)
+	for _, now := range []time.Time{n.Add(time.Minute), n} {
+		item := &config.GenQueueItem{Count: 1, Earliest: now, Latest: now, Now: now, S: s, OQ: oq, Rand: randgen, Cache: &config.CacheItem{}}
+		assert.NoError(t, scenario{}.Gen(item))
+	}
+	close(oq)
+	events := 0
+	for oqi := range oq {
+		events += len(oqi.Events)
+	}
+	assert.Equal(t, 16, events)
+	assert.Equal(t, 0, s.Scenario.Instances())
+}
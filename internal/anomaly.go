@@ -0,0 +1,380 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/coccyx/gogen/logger"
+	"github.com/coccyx/timeparser"
+)
+
+// Anomaly schedules a change in what samples generate for a window of time, so detectors can be tested
+// against data with known anomalies.  If the global anomalyLabels file is set, every window and the events
+// generated in it are written there as ground truth.
+type Anomaly struct {
+	Name           string              `json:"name" yaml:"name"`
+	Type           string              `json:"type" yaml:"type"`
+	Samples        []string            `json:"samples,omitempty" yaml:"samples,omitempty"`
+	Begin          string              `json:"begin" yaml:"begin"`
+	End            string              `json:"end,omitempty" yaml:"end,omitempty"`
+	Duration       string              `json:"duration,omitempty" yaml:"duration,omitempty"`
+	Multiplier     float64             `json:"multiplier,omitempty" yaml:"multiplier,omitempty"`
+	Token          string              `json:"token,omitempty" yaml:"token,omitempty"`
+	Choice         []string            `json:"choice,omitempty" yaml:"choice,omitempty"`
+	WeightedChoice []WeightedChoice    `json:"weightedChoice,omitempty" yaml:"weightedChoice,omitempty"`
+	Distribution   *Distribution       `json:"distribution,omitempty" yaml:"distribution,omitempty"`
+	Lower          int                 `json:"lower,omitempty" yaml:"lower,omitempty"`
+	Upper          int                 `json:"upper,omitempty" yaml:"upper,omitempty"`
+	Lines          []map[string]string `json:"lines,omitempty" yaml:"lines,omitempty"`
+	Count          int                 `json:"count,omitempty" yaml:"count,omitempty"`
+}
+
+// sampleAnomaly is an anomaly scheduled for one sample.  Relative begin and end times are relative to when
+// the sample begins, so they can differ between samples.
+type sampleAnomaly struct {
+	*Anomaly
+	begin time.Time
+	end   time.Time
+	token *Token // Replaces the sample's token for distribution anomalies
+}
+
+// AnomalyLabel is one line of the anomaly labels file.  Window labels give the window of an anomaly in a
+// sample, and events labels give how many events were affected by the anomaly in one interval.  For volume
+// and outage anomalies, Expected is how many events there would have been without the anomaly.
+type AnomalyLabel struct {
+	Label    string `json:"label"`
+	Anomaly  string `json:"anomaly"`
+	Type     string `json:"type"`
+	Sample   string `json:"sample"`
+	Begin    string `json:"begin,omitempty"`
+	End      string `json:"end,omitempty"`
+	Time     string `json:"time,omitempty"`
+	Events   *int   `json:"events,omitempty"`
+	Expected *int   `json:"expected,omitempty"`
+}
+
+// anomalyLabels writes labels to the anomalyLabels file, opening it and writing every window the first
+// time an anomaly affects an event, or when it's closed if no events were affected
+type anomalyLabels struct {
+	path    string
+	windows []AnomalyLabel
+	mutex   sync.Mutex
+	file    *os.File
+	enc     *json.Encoder
+	done    bool
+}
+
+// setupAnomalies validates anomalies and schedules each of them on the samples it affects.  Invalid
+// anomalies are disabled.
+func (c *Config) setupAnomalies() {
+	var labels *anomalyLabels
+	if c.Global.AnomalyLabels != "" && len(c.Anomalies) > 0 {
+		labels = &anomalyLabels{path: os.ExpandEnv(c.Global.AnomalyLabels)}
+		c.labels = labels
+	}
+	for _, a := range c.Anomalies {
+		if err := a.validate(); err != nil {
+			log.Errorf("Invalid anomaly '%s', disabling anomaly: %s", a.Name, err)
+			continue
+		}
+		samples := c.Samples
+		if len(a.Samples) > 0 {
+			samples = make([]*Sample, 0, len(a.Samples))
+			for _, name := range a.Samples {
+				s := c.FindSampleByName(name)
+				if s == nil {
+					log.Errorf("Sample '%s' not found for anomaly '%s', skipping sample", name, a.Name)
+					continue
+				}
+				samples = append(samples, s)
+			}
+		}
+		for _, s := range samples {
+			sa, err := a.schedule(s)
+			if err != nil {
+				log.Errorf("Error scheduling anomaly '%s' for sample '%s', skipping sample: %s", a.Name, s.Name, err)
+				continue
+			}
+			if sa == nil {
+				continue
+			}
+			s.anomalies = append(s.anomalies, sa)
+			if labels != nil {
+				labels.windows = append(labels.windows, AnomalyLabel{
+					Label:   "window",
+					Anomaly: a.Name,
+					Type:    a.Type,
+					Sample:  s.Name,
+					Begin:   sa.begin.Format(time.RFC3339),
+					End:     sa.end.Format(time.RFC3339),
+				})
+			}
+		}
+	}
+}
+
+func (a *Anomaly) validate() error {
+	if a.Name == "" {
+		return fmt.Errorf("no name")
+	}
+	if a.Begin == "" {
+		return fmt.Errorf("no begin")
+	}
+	if a.End == "" && a.Duration == "" {
+		return fmt.Errorf("no end or duration")
+	}
+	switch a.Type {
+	case "volume":
+		if a.Multiplier <= 0 {
+			return fmt.Errorf("multiplier must be greater than zero")
+		}
+	case "outage":
+	case "distribution":
+		if a.Token == "" {
+			return fmt.Errorf("no token")
+		}
+		if len(a.Choice) == 0 && len(a.WeightedChoice) == 0 && a.Distribution == nil && a.Upper == 0 {
+			return fmt.Errorf("no choice, weightedChoice, distribution or upper")
+		}
+		if a.Lower > a.Upper {
+			return fmt.Errorf("lower cannot be greater than upper")
+		}
+		if a.Distribution != nil {
+			if err := a.Distribution.validate(); err != nil {
+				return err
+			}
+		}
+	case "burst":
+		if len(a.Lines) == 0 {
+			return fmt.Errorf("no lines")
+		}
+		if a.Count < 0 {
+			return fmt.Errorf("count cannot be negative")
+		}
+		setDefault(&a.Count, 1)
+	default:
+		return fmt.Errorf("type must be 'volume', 'outage', 'distribution' or 'burst'")
+	}
+	return nil
+}
+
+// override changes o, a copy of the sample's token, to generate the distribution anomaly's values.  Choices
+// replace the token's values, and a distribution or bounds change how int and float random and rated tokens
+// are drawn.
+func (a *Anomaly) override(o *Token) error {
+	if len(a.Choice) > 0 || len(a.WeightedChoice) > 0 {
+		o.Type = "choice"
+		o.Choice = a.Choice
+		if len(a.WeightedChoice) > 0 {
+			o.Type = "weightedChoice"
+			o.WeightedChoice = a.WeightedChoice
+			o.weightedChoiceTotals = make([]int, len(a.WeightedChoice))
+			o.weightedChoiceRunningTotal = 0
+			for i, w := range a.WeightedChoice {
+				o.weightedChoiceRunningTotal += w.Weight
+				o.weightedChoiceTotals[i] = o.weightedChoiceRunningTotal
+			}
+		}
+		return nil
+	}
+	if (o.Type != "random" && o.Type != "rated") || (o.Replacement != "int" && o.Replacement != "float") {
+		return fmt.Errorf("token '%s' is not an int or float random or rated token", o.Name)
+	}
+	if a.Upper != 0 {
+		o.Lower = a.Lower
+		o.Upper = a.Upper
+	}
+	if a.Distribution != nil {
+		o.Distribution = a.Distribution
+	}
+	if o.Distribution != nil {
+		// Copy the distribution, since it's set up for the anomaly's bounds
+		d := *o.Distribution
+		if err := d.setup(o.Lower, o.Upper); err != nil {
+			return err
+		}
+		o.Distribution = &d
+	}
+	return nil
+}
+
+// schedule works out the anomaly's window for s.  Returns nil if it is a distribution anomaly for a token
+// the sample doesn't have and the anomaly applies to every sample.
+func (a *Anomaly) schedule(s *Sample) (*sampleAnomaly, error) {
+	sa := &sampleAnomaly{Anomaly: a}
+	if a.Type == "distribution" {
+		for _, t := range s.Tokens {
+			if t.Name == a.Token {
+				o := t
+				o.Parent = nil
+				if err := a.override(&o); err != nil {
+					return nil, err
+				}
+				sa.token = &o
+				break
+			}
+		}
+		if sa.token == nil {
+			if len(a.Samples) == 0 {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("token '%s' not found", a.Token)
+		}
+	}
+
+	// Relative times are relative to when the sample begins
+	start := s.BeginParsed
+	if start.IsZero() {
+		start = time.Now()
+	}
+	base := func() time.Time {
+		return start
+	}
+	var err error
+	if sa.begin, err = timeparser.TimeParserNow(a.Begin, base); err != nil {
+		return nil, fmt.Errorf("error parsing begin '%s': %s", a.Begin, err)
+	}
+	if a.Duration != "" {
+		d, err := time.ParseDuration(a.Duration)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration '%s'", a.Duration)
+		}
+		sa.end = sa.begin.Add(d)
+	} else if sa.end, err = timeparser.TimeParserNow(a.End, base); err != nil {
+		return nil, fmt.Errorf("error parsing end '%s': %s", a.End, err)
+	}
+	if !sa.end.After(sa.begin) {
+		return nil, fmt.Errorf("end must be after begin")
+	}
+	return sa, nil
+}
+
+func (sa *sampleAnomaly) active(t time.Time) bool {
+	return !t.Before(sa.begin) && t.Before(sa.end)
+}
+
+// AnomalyCount applies active volume and outage anomalies to the count of events to generate at now
+func (s *Sample) AnomalyCount(now time.Time, count int) int {
+	for _, sa := range s.anomalies {
+		if (sa.Type != "volume" && sa.Type != "outage") || !sa.active(now) {
+			continue
+		}
+		expected := count
+		if sa.Type == "outage" {
+			count = 0
+		} else {
+			count = int(math.Floor(float64(count)*sa.Multiplier + 0.5))
+		}
+		s.labelAnomaly(sa.Anomaly, now, count, &expected)
+	}
+	return count
+}
+
+// AnomalyActive returns whether any anomaly is active for the sample at now
+func (s *Sample) AnomalyActive(now time.Time) bool {
+	for _, sa := range s.anomalies {
+		if sa.active(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveAnomalies returns the anomalies of type typ which are active for the sample at now
+func (s *Sample) ActiveAnomalies(now time.Time, typ string) []*Anomaly {
+	var ret []*Anomaly
+	for _, sa := range s.anomalies {
+		if sa.Type == typ && sa.active(now) {
+			ret = append(ret, sa.Anomaly)
+		}
+	}
+	return ret
+}
+
+// LabelAnomaly records in the labels file that events were generated for the sample at now affected by a
+func (s *Sample) LabelAnomaly(a *Anomaly, now time.Time, events int) {
+	s.labelAnomaly(a, now, events, nil)
+}
+
+func (s *Sample) labelAnomaly(a *Anomaly, now time.Time, events int, expected *int) {
+	if s.cfg == nil || s.cfg.labels == nil {
+		return
+	}
+	s.cfg.labels.write(AnomalyLabel{
+		Label:    "events",
+		Anomaly:  a.Name,
+		Type:     a.Type,
+		Sample:   s.Name,
+		Time:     now.Format(time.RFC3339),
+		Events:   &events,
+		Expected: expected,
+	})
+}
+
+// anomalyToken returns the token replacing the sample's token name at now, or nil if there isn't one
+func (s *Sample) anomalyToken(name string, now time.Time) *Token {
+	for _, sa := range s.anomalies {
+		if sa.token != nil && sa.Token == name && sa.active(now) {
+			return sa.token
+		}
+	}
+	return nil
+}
+
+func (l *anomalyLabels) write(label AnomalyLabel) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.open() {
+		l.encode(label)
+	}
+}
+
+// open creates the file and writes the windows if that hasn't been done yet, returning whether labels can be written
+func (l *anomalyLabels) open() bool {
+	if l.done {
+		return false
+	}
+	if l.file == nil {
+		f, err := os.Create(l.path)
+		if err != nil {
+			log.Errorf("Error creating anomaly labels file '%s', not writing labels: %s", l.path, err)
+			l.done = true
+			return false
+		}
+		l.file = f
+		l.enc = json.NewEncoder(f)
+		for _, w := range l.windows {
+			l.encode(w)
+		}
+	}
+	return true
+}
+
+func (l *anomalyLabels) encode(label AnomalyLabel) {
+	if err := l.enc.Encode(label); err != nil {
+		log.Errorf("Error writing anomaly labels file '%s': %s", l.path, err)
+	}
+}
+
+// CloseAnomalyLabels finishes writing the anomaly labels file, if one is configured.  Labels for anything
+// generated afterwards are dropped.
+func (c *Config) CloseAnomalyLabels() {
+	l := c.labels
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.open() {
+		return
+	}
+	if err := l.file.Close(); err != nil {
+		log.Errorf("Error closing anomaly labels file '%s': %s", l.path, err)
+	}
+	l.file = nil
+	l.done = true
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnomalyValidate(t *testing.T) {
+	bad := []*Anomaly{
+		{Type: "outage", Begin: "+1m", Duration: "1m"},
+		{Name: "nobegin", Type: "outage", Duration: "1m"},
+		{Name: "noend", Type: "outage", Begin: "+1m"},
+		{Name: "badtype", Type: "spike", Begin: "+1m", Duration: "1m"},
+		{Name: "nomultiplier", Type: "volume", Begin: "+1m", Duration: "1m"},
+		{Name: "notoken", Type: "distribution", Begin: "+1m", Duration: "1m", Choice: []string{"a"}},
+		{Name: "nochoice", Type: "distribution", Begin: "+1m", Duration: "1m", Token: "a"},
+		{Name: "badbounds", Type: "distribution", Begin: "+1m", Duration: "1m", Token: "a", Lower: 10, Upper: 5},
+		{Name: "baddistribution", Type: "distribution", Begin: "+1m", Duration: "1m", Token: "a", Distribution: &Distribution{Type: "normal"}},
+		{Name: "nolines", Type: "burst", Begin: "+1m", Duration: "1m"},
+	}
+	for _, a := range bad {
+		assert.Error(t, a.validate(), a.Name)
+	}
+	a := &Anomaly{Name: "burst", Type: "burst", Begin: "+1m", Duration: "1m", Lines: []map[string]string{{"_raw": "x"}}}
+	assert.NoError(t, a.validate())
+	assert.Equal(t, 1, a.Count)
+}
+
+func TestAnomalySchedule(t *testing.T) {
+	begin := time.Date(2001, 10, 20, 12, 0, 0, 0, time.Local)
+	s := &Sample{Name: "s", BeginParsed: begin, Tokens: []Token{{Name: "status", Type: "choice", Choice: []string{"200"}}}}
+
+	a := &Anomaly{Name: "spike", Type: "volume", Begin: "+2m", Duration: "90s", Multiplier: 3}
+	sa, err := a.schedule(s)
+	assert.NoError(t, err)
+	assert.Equal(t, begin.Add(2*time.Minute), sa.begin)
+	assert.Equal(t, begin.Add(210*time.Second), sa.end)
+
+	a = &Anomaly{Name: "backwards", Type: "outage", Begin: "+2m", End: "+1m"}
+	_, err = a.schedule(s)
+	assert.Error(t, err)
+
+	// Distribution anomalies for every sample skip samples without the token, but not samples named explicitly
+	a = &Anomaly{Name: "dist", Type: "distribution", Begin: "+1m", Duration: "1m", Token: "missing", Choice: []string{"x"}}
+	sa, err = a.schedule(s)
+	assert.NoError(t, err)
+	assert.Nil(t, sa)
+	a.Samples = []string{"s"}
+	_, err = a.schedule(s)
+	assert.Error(t, err)
+
+	a = &Anomaly{Name: "dist", Type: "distribution", Begin: "+1m", Duration: "1m", Token: "status",
+		WeightedChoice: []WeightedChoice{{Weight: 1, Choice: "500"}, {Weight: 3, Choice: "503"}}}
+	sa, err = a.schedule(s)
+	assert.NoError(t, err)
+	s.anomalies = append(s.anomalies, sa)
+	s.Tokens[0].Parent = s
+
+	randgen := rand.New(rand.NewSource(0))
+	r, _, err := s.Tokens[0].GenReplacement(-1, begin, begin, begin, randgen, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "200", r)
+	now := begin.Add(90 * time.Second)
+	counts := make(map[string]int)
+	for i := 0; i < 400; i++ {
+		r, _, err := s.Tokens[0].GenReplacement(-1, now, now, now, randgen, nil)
+		assert.NoError(t, err)
+		counts[r]++
+	}
+	assert.InDelta(t, 100, counts["500"], 30)
+	assert.InDelta(t, 300, counts["503"], 30)
+}
+
+func TestAnomalyScheduleDistribution(t *testing.T) {
+	begin := time.Date(2001, 10, 20, 12, 0, 0, 0, time.Local)
+	s := &Sample{Name: "s", BeginParsed: begin, Tokens: []Token{
+		{Name: "latency", Type: "random", Replacement: "int", Lower: 0, Upper: 100},
+		{Name: "status", Type: "choice", Choice: []string{"200"}},
+	}}
+
+	// Only int and float random and rated tokens can take a distribution
+	a := &Anomaly{Name: "slow", Type: "distribution", Begin: "+1m", Duration: "1m", Token: "status", Upper: 10}
+	_, err := a.schedule(s)
+	assert.Error(t, err)
+
+	a = &Anomaly{Name: "slow", Type: "distribution", Begin: "+1m", Duration: "1m", Token: "latency",
+		Distribution: &Distribution{Type: "normal", Mean: 900, StdDev: 50}, Lower: 500, Upper: 1500}
+	assert.NoError(t, a.validate())
+	sa, err := a.schedule(s)
+	assert.NoError(t, err)
+	s.anomalies = append(s.anomalies, sa)
+	s.Tokens[0].Parent = s
+
+	mean := func(now time.Time) float64 {
+		randgen := rand.New(rand.NewSource(0))
+		sum := 0
+		for i := 0; i < 1000; i++ {
+			r, _, err := s.Tokens[0].GenReplacement(-1, now, now, now, randgen, nil)
+			assert.NoError(t, err)
+			v, _ := strconv.Atoi(r)
+			sum += v
+		}
+		return float64(sum) / 1000
+	}
+	assert.InDelta(t, 50, mean(begin), 5)
+	assert.InDelta(t, 900, mean(begin.Add(90*time.Second)), 10)
+	// The sample's token is unchanged
+	assert.Nil(t, s.Tokens[0].Distribution)
+	assert.Equal(t, 100, s.Tokens[0].Upper)
+}
+
+func TestAnomalyCount(t *testing.T) {
+	begin := time.Date(2001, 10, 20, 12, 0, 0, 0, time.Local)
+	s := &Sample{Name: "s", BeginParsed: begin}
+	for _, a := range []*Anomaly{
+		{Name: "spike", Type: "volume", Begin: "+1m", Duration: "1m", Multiplier: 2.5},
+		{Name: "down", Type: "outage", Begin: "+3m", Duration: "1m"},
+	} {
+		sa, err := a.schedule(s)
+		assert.NoError(t, err)
+		s.anomalies = append(s.anomalies, sa)
+	}
+	assert.Equal(t, 10, s.AnomalyCount(begin, 10))
+	assert.Equal(t, 25, s.AnomalyCount(begin.Add(time.Minute), 10))
+	assert.Equal(t, 10, s.AnomalyCount(begin.Add(2*time.Minute), 10))
+	assert.Equal(t, 0, s.AnomalyCount(begin.Add(3*time.Minute), 10))
+	assert.Len(t, s.ActiveAnomalies(begin.Add(3*time.Minute), "outage"), 1)
+	assert.Len(t, s.ActiveAnomalies(begin.Add(3*time.Minute), "volume"), 0)
+}
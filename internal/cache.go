@@ -10,4 +10,34 @@ type CacheItem struct {
 	sync.RWMutex
 	UseCache bool
 	SetCache bool
+	Events   *EventCache // Cached events of the sample, shared by every item of its timer
+}
+
+// EventCache holds the events last cached for a sample.  It belongs to the sample's timer, so nothing is
+// cached beyond the run.
+type EventCache struct {
+	mutex  sync.RWMutex
+	events []map[string]string
+	ok     bool
+}
+
+// Get returns the cached events, and whether any have been cached
+func (ec *EventCache) Get() ([]map[string]string, bool) {
+	if ec == nil {
+		return nil, false
+	}
+	ec.mutex.RLock()
+	defer ec.mutex.RUnlock()
+	return ec.events, ec.ok
+}
+
+// Set caches events
+func (ec *EventCache) Set(events []map[string]string) {
+	if ec == nil {
+		return
+	}
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.events = events
+	ec.ok = true
 }
@@ -27,8 +27,11 @@ type Config struct {
 	Templates   []*Template        `json:"templates,omitempty" yaml:"templates,omitempty"`
 	Raters      []*RaterConfig     `json:"raters,omitempty" yaml:"raters,omitempty"`
 	Generators  []*GeneratorConfig `json:"generators,omitempty" yaml:"generators,omitempty"`
+	Entities    []*EntityConfig    `json:"entities,omitempty" yaml:"entities,omitempty"`
+	Anomalies   []*Anomaly         `json:"anomalies,omitempty" yaml:"anomalies,omitempty"`
 	initialized bool
 	cc          ConfigConfig
+	labels      *anomalyLabels
 
 	// Exported but internal use variables
 	Timezone *time.Location `json:"-" yaml:"-"`
@@ -49,6 +52,8 @@ type Global struct {
 	SamplesDir           []string `json:"samplesDir,omitempty" yaml:"samplesDir,omitempty"`
 	AddTime              bool     `json:"addTime,omitempty" yaml:"addTime,omitempty"`
 	CacheIntervals       int      `json:"cacheIntervals,omitempty" yaml:"cacheIntervals,omitempty"`
+	Seed                 int64    `json:"seed,omitempty" yaml:"seed,omitempty"`
+	AnomalyLabels        string   `json:"anomalyLabels,omitempty" yaml:"anomalyLabels,omitempty"`
 }
 
 // Output represents configuration for outputting data
@@ -78,6 +83,7 @@ type ConfigConfig struct {
 	SamplesDir string
 	FullConfig string
 	Export     bool
+	Config     *Config // Already parsed config to build from instead of FullConfig or the config directory
 }
 
 // Share allows accessing the share module from Config without a circular dependency
@@ -161,11 +167,19 @@ func NewConfig() *Config {
 // BuildConfig builds a new config object from the passed ConfigConfig
 func BuildConfig(cc ConfigConfig) *Config {
 	c := &Config{initialized: false, cc: cc}
+	if cc.Config != nil {
+		c = cc.Config
+		c.cc = cc
+	}
 
 	// Setup timezone
 	c.Timezone, _ = time.LoadLocation("Local")
 
-	if len(cc.FullConfig) > 0 {
+	if cc.Config != nil {
+		for i := 0; i < len(c.Samples); i++ {
+			c.Samples[i].realSample = true
+		}
+	} else if len(cc.FullConfig) > 0 {
 		cc.FullConfig = os.ExpandEnv(cc.FullConfig)
 		if strings.HasPrefix(cc.FullConfig, "http") {
 			log.Infof("Fetching config from '%s'", cc.FullConfig)
@@ -245,10 +259,11 @@ func BuildConfig(cc ConfigConfig) *Config {
 		}
 	}
 
-	if len(cc.FullConfig) == 0 {
+	if len(cc.FullConfig) == 0 && cc.Config == nil {
 		loadConfigDir(c, cc.ConfigDir, "templates", &c.Templates)
 		loadConfigDir(c, cc.ConfigDir, "raters", &c.Raters)
 		loadConfigDir(c, cc.ConfigDir, "generators", &c.Generators)
+		loadConfigDir(c, cc.ConfigDir, "entities", &c.Entities)
 
 		c.readSamplesDir(cc.SamplesDir)
 	}
@@ -277,6 +292,9 @@ func BuildConfig(cc ConfigConfig) *Config {
 						tempend := c.Samples[i].End
 						temp := *c.Samples[j]
 						c.Samples[i] = &temp
+						if temp.Scenario != nil {
+							c.Samples[i].Scenario = temp.Scenario.clone()
+						}
 						c.Samples[i].Disabled = false
 						c.Samples[i].Name = tempname
 						c.Samples[i].FromSample = ""
@@ -325,6 +343,11 @@ func BuildConfig(cc ConfigConfig) *Config {
 		}
 	}
 
+	// Entity pools can reference samples, so resolve them before samples are cleaned
+	for i := 0; i < len(c.Entities); i++ {
+		c.validateEntity(c.Entities[i])
+	}
+
 	// Setup time and facility
 	c.SetupSystemTokens()
 
@@ -352,8 +375,18 @@ func BuildConfig(cc ConfigConfig) *Config {
 				os.Remove(".tmp.yml")
 			}
 		}
+		c.SetSeed(c.Global.Seed)
+	}
+
+	// Samples look up raters in the config they were built in, including those merged from mixes
+	for _, s := range c.Samples {
+		s.cfg = c
 	}
 
+	// Anomalies can affect any sample, including those merged from mixes
+	if !cc.Export {
+		c.setupAnomalies()
+	}
 	c.initialized = true
 	return c
 }
@@ -394,6 +427,9 @@ func (c *Config) mergeMixConfig(nc *Config, m *Mix) {
 	for i := range nc.Raters {
 		c.Raters = append(c.Raters, nc.Raters[i])
 	}
+	for i := range nc.Entities {
+		c.Entities = append(c.Entities, nc.Entities[i])
+	}
 }
 
 func (c *Config) readSamplesDir(samplesDir string) {
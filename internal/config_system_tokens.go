@@ -9,7 +9,7 @@ import (
 
 // SetupSystemTokens adds tokens like time and facility to samples based on configuration
 func (c *Config) SetupSystemTokens() {
-	addToken := func(s *Sample, tokenName string, tokenType string, tokenReplacement string) {
+	addToken := func(s *Sample, tokenName string, tokenType string, tokenReplacement string, tokenPrecision int) {
 		// If there's no _time token, add it to make sure we have a timestamp field in every event
 		tokenfound := false
 		for _, t := range s.Tokens {
@@ -40,6 +40,7 @@ func (c *Config) SetupSystemTokens() {
 			if tokenReplacement != "" {
 				tt.Replacement = tokenReplacement
 			}
+			tt.Precision = tokenPrecision
 			s.Tokens = append(s.Tokens, tt)
 			if s.SinglePass {
 				for j := 0; j < len(s.BrokenLines); j++ {
@@ -81,13 +82,16 @@ func (c *Config) SetupSystemTokens() {
 		// Use epochtimestamp for Splunk, or different formats for rfc3164 or rfc5424
 		var tokenType string
 		var tokenReplacement string
+		var tokenPrecision int
 		tokenName := "_time"
 		if c.Global.Output.OutputTemplate == "elasticsearch" {
 			tokenName = "@timestamp"
 			tokenType = "gotimestamp"
 			tokenReplacement = "2006-01-02T15:04:05.999Z07:00"
 		} else if !syslogOutput {
+			// Millisecond precision so high rate events don't collapse onto the same second
 			tokenType = "epochtimestamp"
+			tokenPrecision = 3
 		} else if c.Global.Output.OutputTemplate == "rfc3164" {
 			tokenType = "gotimestamp"
 			tokenReplacement = "Jan _2 15:04:05"
@@ -98,7 +102,7 @@ func (c *Config) SetupSystemTokens() {
 		hostname, _ := os.Hostname()
 		for i := 0; i < len(c.Samples); i++ {
 			s := c.Samples[i]
-			addToken(s, tokenName, tokenType, tokenReplacement) // Timestamp
+			addToken(s, tokenName, tokenType, tokenReplacement, tokenPrecision) // Timestamp
 			// Add fields for syslog output
 			if syslogOutput {
 				addField(s, "priority", fmt.Sprintf("%d", defaultSyslogPriority))
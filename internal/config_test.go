@@ -175,6 +175,14 @@ func TestValidate(t *testing.T) {
 		"validate-badrandom",
 		"validate-earliest-latest",
 		"validate-nolines",
+		"validate-distribution",
+		"validate-distribution-string",
+		"validate-distribution-range",
+		"validate-distribution-params",
+		"validate-badregex",
+		"validate-spreadtime",
+		"validate-epoch-unit",
+		"validate-badpattern",
 	}
 	for _, v := range checks {
 		s = FindSampleInFile(home, v)
@@ -207,6 +215,58 @@ func TestSinglePass(t *testing.T) {
 	assert.Len(t, s.BrokenLines[1]["_raw"], 6)
 }
 
+func TestLinePlans(t *testing.T) {
+	// Setup environment
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	home := filepath.Join("..", "tests", "singlepass")
+
+	s := FindSampleInFile(home, "test1")
+	assert.Nil(t, s.LinePlans)
+
+	s = FindSampleInFile(home, "partial-tokens")
+	assert.False(t, s.SinglePass)
+	assert.Len(t, s.LinePlans, 2)
+	assert.Len(t, s.LinePlans[0].Broken["_raw"], 2)
+	assert.Len(t, s.LinePlans[1].Broken["_raw"], 4)
+	for _, p := range s.LinePlans {
+		assert.NotContains(t, p.Broken, "other")
+		assert.Len(t, p.Dynamic["other"], 2)
+	}
+}
+
+func TestLinePlansChained(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	home := filepath.Join("..", "tests", "singlepass")
+
+	// greeting generates text matched by name, and id by idregex, so _raw is replaced one token after another
+	s := FindSampleInFile(home, "chained")
+	assert.False(t, s.SinglePass)
+	assert.Len(t, s.LinePlans, 1)
+	assert.NotContains(t, s.LinePlans[0].Broken, "_raw")
+	assert.Len(t, s.LinePlans[0].Dynamic["_raw"], 4)
+	// A timestamp can't generate $host$
+	assert.Len(t, s.LinePlans[0].Broken["other"], 3)
+	assert.Equal(t, []string{"_raw", "other"}, s.LinePlans[0].Fields)
+}
+
+func TestTokenMayChain(t *testing.T) {
+	static := &Token{Type: "static", Replacement: "a $b$", Format: "template"}
+	assert.True(t, static.mayChain(&Token{Format: "template", Token: "$b$"}))
+	assert.False(t, static.mayChain(&Token{Format: "template", Token: "$c$"}))
+	assert.False(t, static.mayChain(&Token{Format: "regex", Token: `(\d+)`}))
+	random := &Token{Type: "random", Replacement: "int"}
+	assert.True(t, random.mayChain(&Token{Format: "regex", Token: `(\d+)`}))
+	assert.True(t, random.mayChain(&Token{Format: "template", Token: "10"}))
+	assert.False(t, random.mayChain(&Token{Format: "template", Token: "$n$"}))
+	script := &Token{Type: "script"}
+	assert.True(t, script.mayChain(&Token{Format: "template", Token: "$n$"}))
+	// Entities haven't been generated when line plans are computed
+	entity := &Token{Type: "entity", SrcField: "host", entity: &EntityConfig{}}
+	assert.True(t, entity.mayChain(&Token{Format: "template", Token: "$n$"}))
+}
+
 func TestReplay(t *testing.T) {
 	// Setup environment
 	os.Setenv("GOGEN_HOME", "..")
@@ -219,6 +279,10 @@ func TestReplay(t *testing.T) {
 		"bad-strptime-timestamp",
 		"bad-go-timestamp",
 		"bad-epoch-timestamp",
+		"bad-replay-speed",
+		"bad-replay-maxgap",
+		"bad-replay-eof",
+		"bad-replay-file",
 	}
 	for _, v := range checks {
 		s := FindSampleInFile(home, v)
@@ -227,6 +291,13 @@ func TestReplay(t *testing.T) {
 
 	s := FindSampleInFile(home, "replay5")
 	assert.Equal(t, []time.Duration{(1 * time.Second), (5 * time.Second), (10 * time.Second), (20 * time.Second), 13187500000}, s.ReplayOffsets)
+
+	s = FindSampleInFile(home, "replay-speed")
+	assert.Equal(t, 4*time.Second, s.ReplayMaxGapParsed)
+	assert.Equal(t, 500*time.Millisecond, s.ReplayGap(0))
+	assert.Equal(t, 2500*time.Millisecond, s.ReplayGap(1))
+	assert.Equal(t, 4*time.Second, s.ReplayGap(2))
+	assert.Equal(t, 4*time.Second, s.ReplayGap(3))
 }
 
 func FindSampleInFile(home string, name string) *Sample {
@@ -1048,10 +1119,10 @@ samples:
 	c := NewConfig()
 	s := c.FindSampleByName("scripttest")
 	assert.NotNil(t, s, "sample with script token should not be disabled")
-	// Check that script token has mutex
+	// Check that script token has its script compiled
 	for _, tk := range s.Tokens {
 		if tk.Name == "sc" {
-			assert.NotNil(t, tk.mutex, "script token should have mutex initialized")
+			assert.NotNil(t, tk.luaScript, "script token should have script compiled")
 		}
 	}
 }
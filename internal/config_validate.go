@@ -1,8 +1,14 @@
 package internal
 
 import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -30,7 +36,7 @@ func (c *Config) validate(s *Sample) {
 	if len(s.Name) == 0 {
 		s.Disabled = true
 		s.realSample = false
-	} else if len(s.Lines) == 0 && (s.Generator == "sample" || s.Generator == "replay") {
+	} else if len(s.Lines) == 0 && (s.Generator == "sample" || (s.Generator == "replay" && s.ReplayFile == "")) {
 		s.Disabled = true
 		s.realSample = false
 		log.Errorf("Disabling sample '%s', no lines in sample", s.Name)
@@ -74,13 +80,20 @@ func (c *Config) validate(s *Sample) {
 		s.Disabled = true
 		return
 	}
+	if s.SpreadTime != "" && s.SpreadTime != "even" && s.SpreadTime != "poisson" {
+		log.Errorf("SpreadTime must be 'even' or 'poisson' for sample '%s', disabling Sample", s.Name)
+		s.Disabled = true
+		return
+	}
 	if s.Interval == 0 && s.Generator != "replay" {
 		log.Infof("No interval set for sample '%s', setting endIntervals to 1", s.Name)
 		s.EndIntervals = 1
 	}
 
 	c.validateTokens(s)
+	c.orderTokens(s)
 	c.computeSinglePass(s)
+	c.computeLinePlans(s)
 	c.setupGenerator(s)
 }
 
@@ -150,7 +163,26 @@ func (c *Config) resolveTokenSamples(s *Sample) {
 // validateTokens checks token configurations for validity, disabling the sample if any token is invalid.
 func (c *Config) validateTokens(s *Sample) {
 	for i, t := range s.Tokens {
+		if t.Format == "regex" {
+			re, err := regexp.Compile(t.Token)
+			if err != nil {
+				log.Errorf("Invalid regex '%s' for token '%s' in sample '%s', disabling Sample: %s", t.Token, t.Name, s.Name, err)
+				s.Disabled = true
+			}
+			s.Tokens[i].re = re
+		}
+		if t.Type == "timestamp" || t.Type == "gotimestamp" || t.Type == "epochtimestamp" {
+			if err := s.Tokens[i].setupTimezone(s.Timezone); err != nil {
+				log.Errorf("Invalid timezone for token '%s' in sample '%s', disabling Sample: %s", t.Name, s.Name, err)
+				s.Disabled = true
+			}
+		}
 		switch t.Type {
+		case "epochtimestamp":
+			if err := t.validateEpoch(); err != nil {
+				log.Errorf("Invalid epochtimestamp for token '%s' in sample '%s', disabling Sample: %s", t.Name, s.Name, err)
+				s.Disabled = true
+			}
 		case "random", "rated":
 			if t.Replacement == "int" || t.Replacement == "float" {
 				if t.Lower > t.Upper {
@@ -159,14 +191,32 @@ func (c *Config) validateTokens(s *Sample) {
 				} else if t.Upper == 0 {
 					log.Errorf("Upper cannot be zero for token '%s' in sample '%s', disabling Sample", t.Name, s.Name)
 					s.Disabled = true
+				} else if t.Distribution != nil {
+					if err := t.Distribution.setup(t.Lower, t.Upper); err != nil {
+						log.Errorf("Invalid distribution for token '%s' in sample '%s', disabling Sample: %s", t.Name, s.Name, err)
+						s.Disabled = true
+					}
 				}
+			} else if t.Distribution != nil {
+				log.Errorf("Distribution is only valid for int or float replacements for token '%s' in sample '%s', disabling Sample", t.Name, s.Name)
+				s.Disabled = true
 			} else if t.Replacement == "string" || t.Replacement == "hex" {
 				if t.Length == 0 {
 					log.Errorf("Length cannot be zero for token '%s' in sample '%s', disabling Sample", t.Name, s.Name)
 					s.Disabled = true
 				}
+			} else if t.Replacement == "pattern" && t.Type == "random" {
+				if err := s.Tokens[i].setupPattern(); err != nil {
+					log.Errorf("Invalid pattern for token '%s' in sample '%s', disabling Sample: %s", t.Name, s.Name, err)
+					s.Disabled = true
+				}
+			} else if t.Replacement == "ipv4" || t.Replacement == "ipv6" {
+				if err := s.Tokens[i].setupIP(); err != nil {
+					log.Errorf("Invalid IP settings for token '%s' in sample '%s', disabling Sample: %s", t.Name, s.Name, err)
+					s.Disabled = true
+				}
 			} else {
-				if t.Replacement != "guid" && t.Replacement != "ipv4" && t.Replacement != "ipv6" {
+				if t.Replacement != "guid" {
 					log.Errorf("Replacement '%s' is invalid for token '%s' in sample '%s'", t.Replacement, t.Name, s.Name)
 					s.Disabled = true
 				}
@@ -176,6 +226,11 @@ func (c *Config) validateTokens(s *Sample) {
 				log.Errorf("Zero choice items for token '%s' in sample '%s', disabling Sample", t.Name, s.Name)
 				s.Disabled = true
 			}
+		case "markov":
+			if err := s.Tokens[i].setupMarkov(); err != nil {
+				log.Errorf("Invalid markov chain for token '%s' in sample '%s', disabling Sample: %s", t.Name, s.Name, err)
+				s.Disabled = true
+			}
 		case "weightedChoice":
 			if len(t.WeightedChoice) == 0 || t.WeightedChoice == nil {
 				log.Errorf("Zero choice items for token '%s' in sample '%s', disabling Sample", t.Name, s.Name)
@@ -193,11 +248,37 @@ func (c *Config) validateTokens(s *Sample) {
 					break
 				}
 			}
+		case "entity":
+			e := c.FindEntity(t.Entity)
+			if e == nil || !e.valid {
+				log.Errorf("Entity '%s' not found or invalid for token '%s' in sample '%s', disabling Sample", t.Entity, t.Name, s.Name)
+				s.Disabled = true
+			} else if !e.hasAttribute(t.SrcField) {
+				log.Errorf("Source field '%s' is not an attribute of entity '%s' for token '%s' in sample '%s', disabling Sample", t.SrcField, t.Entity, t.Name, s.Name)
+				s.Disabled = true
+			} else {
+				s.Tokens[i].entity = e
+			}
+		case "sequence":
+			if err := s.Tokens[i].setupSequence(); err != nil {
+				log.Errorf("Invalid sequence for token '%s' in sample '%s', disabling Sample: %s", t.Name, s.Name, err)
+				s.Disabled = true
+			}
+		case "expression":
+			if err := s.Tokens[i].setupExpression(); err != nil {
+				log.Errorf("Invalid expression for token '%s' in sample '%s', disabling Sample: %s", t.Name, s.Name, err)
+				s.Disabled = true
+			}
 		case "script":
-			s.Tokens[i].mutex = &sync.Mutex{}
+			script, err := NewLuaScript(t.Name, t.Script, t.luaState, nil)
+			if err != nil {
+				log.Errorf("Error parsing script for token '%s' in sample '%s', disabling Sample: %s", t.Name, s.Name, err)
+				s.Disabled = true
+			}
+			s.Tokens[i].luaScript = script
 			for k, v := range t.Init {
 				vAsNum, err := strconv.ParseFloat(v, 64)
-				if err != nil {
+				if err == nil {
 					t.luaState.RawSet(lua.LString(k), lua.LNumber(vAsNum))
 				} else {
 					t.luaState.RawSet(lua.LString(k), lua.LString(v))
@@ -207,6 +288,71 @@ func (c *Config) validateTokens(s *Sample) {
 	}
 }
 
+// orderTokens works out the order to generate tokens in for samples with expression tokens, so that every
+// expression is evaluated after the tokens it references.  Circular references disable the sample.
+func (c *Config) orderTokens(s *Sample) {
+	s.TokenOrder = nil
+	hasExpression := false
+	byName := make(map[string][]int)
+	for i, t := range s.Tokens {
+		if t.Type == "expression" {
+			hasExpression = true
+		}
+		s.Tokens[i].ExprValue = false
+		byName[t.Name] = append(byName[t.Name], i)
+	}
+	if !hasExpression {
+		return
+	}
+
+	placed := make([]bool, len(s.Tokens))
+	ready := func(i int) bool {
+		for _, dep := range s.Tokens[i].exprDeps {
+			for _, j := range byName[dep] {
+				if j != i && !placed[j] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	order := make([]int, 0, len(s.Tokens))
+outer:
+	for len(order) < len(s.Tokens) {
+		for i := range s.Tokens {
+			if !placed[i] && ready(i) {
+				placed[i] = true
+				order = append(order, i)
+				continue outer
+			}
+		}
+		unplaced := make([]string, 0)
+		for i, t := range s.Tokens {
+			if !placed[i] {
+				unplaced = append(unplaced, t.Name)
+			}
+		}
+		log.Errorf("Circular reference between expression tokens '%s' in sample '%s', disabling Sample", strings.Join(unplaced, "', '"), s.Name)
+		s.Disabled = true
+		return
+	}
+	// Only expressions and the tokens they reference need a value before replacement.  Walking the order
+	// backwards reaches every expression before the tokens it references.
+	for i := len(order) - 1; i >= 0; i-- {
+		t := &s.Tokens[order[i]]
+		t.ExprValue = t.Type == "expression" || t.ExprValue
+		if !t.ExprValue {
+			continue
+		}
+		for _, dep := range t.exprDeps {
+			for _, j := range byName[dep] {
+				s.Tokens[j].ExprValue = true
+			}
+		}
+	}
+	s.TokenOrder = order
+}
+
 // computeSinglePass checks if SinglePass optimization is feasible for the sample
 // by verifying all tokens can be located in each line without overlapping.
 func (c *Config) computeSinglePass(s *Sample) {
@@ -278,31 +424,7 @@ outer:
 			if len(tlines) >= i && len(tlines) > 0 {
 				bline := make(map[string][]StringOrToken)
 				for field := range line {
-					var bfield []StringOrToken
-					if _, ok := tlines[i][field]; !ok {
-						bf := StringOrToken{T: nil, S: line[field]}
-						bfield = append(bfield, bf)
-					} else {
-						lastpos := 0
-						for _, tp := range tlines[i][field] {
-							if tp.Pos1 == 0 {
-								bf := StringOrToken{T: &s.Tokens[tp.Token], S: ""}
-								bfield = append(bfield, bf)
-								lastpos = tp.Pos2
-							} else {
-								bf := StringOrToken{T: nil, S: s.Lines[i][field][lastpos:tp.Pos1]}
-								bfield = append(bfield, bf)
-								bf = StringOrToken{T: &s.Tokens[tp.Token], S: ""}
-								bfield = append(bfield, bf)
-								lastpos = tp.Pos2
-							}
-						}
-						if lastpos < len(s.Lines[i][field]) {
-							bf := StringOrToken{T: nil, S: s.Lines[i][field][lastpos:]}
-							bfield = append(bfield, bf)
-						}
-					}
-					bline[field] = bfield
+					bline[field] = breakField(s, line[field], tlines[i][field])
 				}
 				s.BrokenLines = append(s.BrokenLines, bline)
 			}
@@ -310,10 +432,211 @@ outer:
 	}
 }
 
-// setupGenerator configures the sample's generator: replay offsets for replay generators,
-// or custom Lua generator linkage for non-sample generators.
+// breakField splits a field's value into strings and tokens at the sorted, non-overlapping token positions
+func breakField(s *Sample, value string, positions tokenspos) []StringOrToken {
+	var bfield []StringOrToken
+	lastpos := 0
+	for _, tp := range positions {
+		if tp.Pos1 > lastpos {
+			bfield = append(bfield, StringOrToken{T: nil, S: value[lastpos:tp.Pos1]})
+		}
+		bfield = append(bfield, StringOrToken{T: &s.Tokens[tp.Token], S: ""})
+		lastpos = tp.Pos2
+	}
+	if lastpos < len(value) || len(bfield) == 0 {
+		bfield = append(bfield, StringOrToken{T: nil, S: value[lastpos:]})
+	}
+	return bfield
+}
+
+// computeLinePlans precompiles the replacements for each line of samples which can't be SinglePass.  Unlike
+// SinglePass, this is decided per line and field, so template tokens missing from some lines cost nothing, and only
+// fields with overlapping or unmatched regex tokens need to be replaced one token after another.
+func (c *Config) computeLinePlans(s *Sample) {
+	s.LinePlans = nil
+	if s.Disabled || s.SinglePass {
+		return
+	}
+	s.LinePlans = make([]LinePlan, 0, len(s.Lines))
+	for _, l := range s.Lines {
+		positions := make(map[string]tokenspos)
+		dynamic := make(map[string]bool)
+		for j, t := range s.Tokens {
+			if t.Disabled {
+				continue
+			}
+			fieldval, ok := l[t.Field]
+			if !ok {
+				// Template tokens create the field if it doesn't exist
+				dynamic[t.Field] = true
+				continue
+			}
+			offsets, err := t.GetReplacementOffsets(fieldval)
+			if err != nil {
+				// A regex may only match once earlier tokens have been replaced
+				if t.Format == "regex" {
+					dynamic[t.Field] = true
+				}
+				continue
+			}
+			for _, offset := range offsets {
+				positions[t.Field] = append(positions[t.Field], tokenpos{Pos1: offset[0], Pos2: offset[1], Token: j})
+			}
+		}
+		for field, tp := range positions {
+			sort.Slice(tp, func(a, b int) bool { return tp[a].Pos1 < tp[b].Pos1 })
+			maxpos := 0
+			for _, pos := range tp {
+				if pos.Pos1 < maxpos {
+					dynamic[field] = true
+					break
+				}
+				maxpos = pos.Pos2
+			}
+		}
+
+		// Multipass replaces tokens one after another, so a token can match text inserted by an earlier one.
+		// Fields where that might happen are replaced the same way.
+		for a := range s.Tokens {
+			for b := a + 1; b < len(s.Tokens); b++ {
+				ta, tb := &s.Tokens[a], &s.Tokens[b]
+				if !ta.Disabled && !tb.Disabled && ta.Field == tb.Field && !dynamic[ta.Field] && ta.mayChain(tb) {
+					dynamic[ta.Field] = true
+				}
+			}
+		}
+
+		plan := LinePlan{Broken: make(map[string][]StringOrToken, len(l)), Dynamic: make(map[string][]*Token, len(dynamic))}
+		for field, value := range l {
+			if !dynamic[field] {
+				plan.Broken[field] = breakField(s, value, positions[field])
+			}
+		}
+		last := make(map[string]int)
+		for j := range s.Tokens {
+			if s.Tokens[j].Disabled {
+				continue
+			}
+			last[s.Tokens[j].Field] = j + 1
+			if dynamic[s.Tokens[j].Field] {
+				plan.Dynamic[s.Tokens[j].Field] = append(plan.Dynamic[s.Tokens[j].Field], &s.Tokens[j])
+			}
+		}
+		for field := range plan.Broken {
+			plan.Fields = append(plan.Fields, field)
+		}
+		for field := range plan.Dynamic {
+			plan.Fields = append(plan.Fields, field)
+		}
+		sort.Slice(plan.Fields, func(a, b int) bool {
+			fa, fb := plan.Fields[a], plan.Fields[b]
+			if (fa == "_channel") != (fb == "_channel") {
+				return fb == "_channel"
+			}
+			if last[fa] != last[fb] {
+				return last[fa] < last[fb]
+			}
+			return fa < fb
+		})
+		s.LinePlans = append(s.LinePlans, plan)
+	}
+}
+
+// mayChain returns whether next, replaced after t, could match text in a replacement generated by t.  When
+// every value t generates is known, they're checked against next's pattern.  Otherwise, a template token
+// could only match if t can generate every character of it, and a regex token is assumed to match.
+func (t *Token) mayChain(next *Token) bool {
+	if outputs, ok := t.outputs(); ok {
+		for _, out := range outputs {
+			if next.matches(out) {
+				return true
+			}
+		}
+		return false
+	}
+	if next.Format != "template" {
+		return true
+	}
+	chars := t.outputChars()
+	if chars == "" {
+		return true
+	}
+	for _, r := range next.Token {
+		if !strings.ContainsRune(chars, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches returns whether the token's pattern is found in value
+func (t *Token) matches(value string) bool {
+	offsets, err := t.GetReplacementOffsets(value)
+	return err == nil && len(offsets) > 0
+}
+
+// outputs returns every value the token can generate, or false if they can't be listed
+func (t *Token) outputs() ([]string, bool) {
+	switch t.Type {
+	case "static":
+		return []string{t.Replacement}, true
+	case "choice":
+		return t.Choice, true
+	case "weightedChoice":
+		outputs := make([]string, 0, len(t.WeightedChoice))
+		for _, wc := range t.WeightedChoice {
+			outputs = append(outputs, wc.Choice)
+		}
+		return outputs, true
+	case "fieldChoice":
+		outputs := make([]string, 0, len(t.FieldChoice))
+		for _, fc := range t.FieldChoice {
+			outputs = append(outputs, fc[t.SrcField])
+		}
+		return outputs, true
+	}
+	// Entity pools aren't generated until the seed is set, after samples are validated, so entity tokens
+	// can generate anything
+	return nil, false
+}
+
+// outputChars returns the characters the token's replacements can be made of, or "" if they can be anything
+func (t *Token) outputChars() string {
+	const digits = "0123456789"
+	switch t.Type {
+	case "timestamp", "gotimestamp":
+		// Names of months and days, zone offsets, and whatever the format itself contains
+		return randStringLetters + " +-:.," + t.Replacement
+	case "epochtimestamp":
+		return digits + ".-"
+	case "sequence":
+		return digits + "-"
+	case "random", "rated":
+		switch t.Replacement {
+		case "int", "float":
+			return digits + ".-"
+		case "string":
+			return randStringLetters
+		case "hex":
+			return randHexLetters
+		case "guid":
+			return digits + "abcdef-"
+		case "ipv4":
+			return digits + "."
+		case "ipv6":
+			return digits + "abcdef:."
+		}
+	}
+	return ""
+}
+
+// setupGenerator configures the sample's generator: replay offsets for replay generators, the state
+// machine for scenario generators, or custom Lua generator linkage for other generators.  Generators not defined in the
+// config must have been registered with RegisterGenerator.
 func (c *Config) setupGenerator(s *Sample) {
-	if s.Generator == "replay" {
+	if s.Generator == "replay" && s.ReplayFile != "" {
+		c.setupReplayStream(s)
+	} else if s.Generator == "replay" {
 		s.ReplayOffsets = make([]time.Duration, len(s.Lines))
 		var lastts time.Time
 		var avgOffset time.Duration
@@ -359,6 +682,22 @@ func (c *Config) setupGenerator(s *Sample) {
 			"sample":        s.Name,
 			"ReplayOffsets": s.ReplayOffsets,
 		}).Debugf("ReplayOffsets values")
+	}
+	if s.Generator == "replay" {
+		if s.ReplaySpeed < 0 {
+			log.Errorf("ReplaySpeed cannot be negative for sample '%s', disabling sample", s.Name)
+			s.Disabled = true
+		}
+		if s.ReplayMaxGap != "" {
+			if d, err := time.ParseDuration(s.ReplayMaxGap); err != nil || d <= 0 {
+				log.Errorf("Invalid replayMaxGap '%s' for sample '%s', disabling sample", s.ReplayMaxGap, s.Name)
+				s.Disabled = true
+			} else {
+				s.ReplayMaxGapParsed = d
+			}
+		}
+	} else if s.Generator == "scenario" {
+		c.setupScenario(s)
 	} else if s.Generator != "sample" {
 		for _, g := range c.Generators {
 			if g.Name == s.Generator {
@@ -369,44 +708,275 @@ func (c *Config) setupGenerator(s *Sample) {
 				}
 			}
 		}
-		if s.CustomGenerator == nil {
+		if s.CustomGenerator == nil && FindGeneratorFactory(s.Generator) == nil {
 			log.Errorf("Generator '%s' not found for sample '%s', disabling sample", s.Generator, s.Name)
 			s.Disabled = true
 		}
 	}
 }
 
+// setupReplayStream sets up the sample's ReplayFile for streaming instead of using lines, computing offsets
+// from the first timestamp token as the file is read.  The file is opened when the sample starts generating.
+func (c *Config) setupReplayStream(s *Sample) {
+	setDefault(&s.ReplayEOF, "loop")
+	if s.ReplayEOF != "loop" && s.ReplayEOF != "stop" {
+		log.Errorf("ReplayEOF must be 'loop' or 'stop' for sample '%s', disabling sample", s.Name)
+		s.Disabled = true
+		return
+	}
+	path := c.findFile(s.ReplayFile)
+	for _, t := range s.Tokens {
+		if t.Type == "timestamp" || t.Type == "gotimestamp" || t.Type == "epochtimestamp" {
+			if _, err := os.Stat(path); err != nil {
+				log.Errorf("Error finding replay file for sample '%s', disabling sample: %s", s.Name, err)
+				s.Disabled = true
+				return
+			}
+			s.ReplayStream = NewReplayStream(path, s.ReplayEOF == "loop", t)
+			return
+		}
+	}
+	log.Errorf("No timestamp token to replay file '%s' for sample '%s', disabling sample", s.ReplayFile, s.Name)
+	s.Disabled = true
+}
+
+// setupScenario checks the sample's scenario and that each of its variables names one of the sample's tokens
+func (c *Config) setupScenario(s *Sample) {
+	if s.Scenario == nil {
+		log.Errorf("No scenario for sample '%s', disabling sample", s.Name)
+		s.Disabled = true
+		return
+	}
+	if err := s.Scenario.setup(); err != nil {
+		log.Errorf("Invalid scenario for sample '%s', disabling sample: %s", s.Name, err)
+		s.Disabled = true
+		return
+	}
+	if s.TokenOrder != nil {
+		log.Errorf("Expression tokens are not supported by the scenario generator in sample '%s', disabling sample", s.Name)
+		s.Disabled = true
+		return
+	}
+outer:
+	for _, v := range s.Scenario.Vars {
+		for _, t := range s.Tokens {
+			if t.Name == v {
+				continue outer
+			}
+		}
+		log.Errorf("Scenario variable '%s' is not a token in sample '%s', disabling sample", v, s.Name)
+		s.Disabled = true
+		return
+	}
+}
+
+// findFile expands environment variables in path and finds the file as given, or if it's relative and
+// doesn't exist, in the samples directories
+func (c *Config) findFile(path string) string {
+	path = os.ExpandEnv(path)
+	if _, err := os.Stat(path); os.IsNotExist(err) && !filepath.IsAbs(path) {
+		for _, sd := range append([]string{c.cc.SamplesDir}, c.Global.SamplesDir...) {
+			if _, err := os.Stat(filepath.Join(sd, path)); err == nil {
+				return filepath.Join(sd, path)
+			}
+		}
+	}
+	return path
+}
+
 // validateRater returns a copy of the rater with the Options properly cast
 func (c *Config) validateRater(r *RaterConfig) {
 	configRaterKeys := map[string]bool{
 		"HourOfDay":    true,
 		"MinuteOfHour": true,
 		"DayOfWeek":    true,
+		"DayOfMonth":   true,
+		"MonthOfYear":  true,
 	}
 
 	opt := make(map[string]interface{})
 	for k, v := range r.Options {
-		var newvset interface{}
 		if configRaterKeys[k] {
-			newv := make(map[int]float64)
-			vcast := v.(map[interface{}]interface{})
-			for k2, v2 := range vcast {
-				k2int := k2.(int)
-				v2float, ok := v2.(float64)
-				if !ok {
-					v2int, ok := v2.(int)
-					if !ok {
-						log.Fatalf("Rater value '%#v' of key '%#v' for rater '%s' in '%s' is not a float or int", v2, k2, r.Name, k)
-					}
-					v2float = float64(v2int)
-				}
-				newv[k2int] = v2float
+			m, err := rateMultipliers(v)
+			if err != nil {
+				log.Errorf("Invalid %s for rater '%s', ignoring: %s", k, r.Name, err)
+				continue
 			}
-			newvset = newv
-		} else {
-			newvset = v
+			v = m
 		}
-		opt[k] = newvset
+		opt[k] = v
 	}
 	r.Options = opt
+
+	if r.Type == "config" {
+		c.setupCalendar(r)
+	} else if r.Type == "timeseries" {
+		c.setupTimeSeries(r)
+	} else if r.Type == "chain" {
+		validateChainRater(r)
+	}
+}
+
+// rateMultipliers casts the options of a config rater, like HourOfDay, to a map of multipliers.  YAML
+// gives map[interface{}]interface{}, but configs built in Go may set map[string]interface{} or
+// map[int]float64 directly.
+func rateMultipliers(v interface{}) (map[int]float64, error) {
+	m := make(map[int]float64)
+	switch vcast := v.(type) {
+	case map[int]float64:
+		for k, v2 := range vcast {
+			m[k] = v2
+		}
+		return m, nil
+	case map[int]int:
+		for k, v2 := range vcast {
+			m[k] = float64(v2)
+		}
+		return m, nil
+	case map[interface{}]interface{}:
+		for k, v2 := range vcast {
+			if err := setMultiplier(m, k, v2); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for k, v2 := range vcast {
+			if err := setMultiplier(m, k, v2); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("'%#v' is not a map of multipliers", v)
+	}
+	return m, nil
+}
+
+// setMultiplier sets key k of m to the multiplier v, casting both from what the config was parsed as
+func setMultiplier(m map[int]float64, k, v interface{}) error {
+	var key int
+	switch kcast := k.(type) {
+	case int:
+		key = kcast
+	case int64:
+		key = int(kcast)
+	case float64:
+		if kcast != math.Trunc(kcast) {
+			return fmt.Errorf("key '%v' is not an int", k)
+		}
+		key = int(kcast)
+	case string:
+		// JSON object keys are always strings
+		i, err := strconv.Atoi(kcast)
+		if err != nil {
+			return fmt.Errorf("key '%v' is not an int", k)
+		}
+		key = i
+	default:
+		return fmt.Errorf("key '%#v' is not an int", k)
+	}
+	f, ok := toFloat(v)
+	if !ok {
+		return fmt.Errorf("value '%#v' of key '%v' is not a float or int", v, k)
+	}
+	m[key] = f
+	return nil
+}
+
+// toFloat casts a number parsed from YAML or JSON, or set in Go, to a float64
+func toFloat(v interface{}) (float64, bool) {
+	switch vcast := v.(type) {
+	case float64:
+		return vcast, true
+	case float32:
+		return float64(vcast), true
+	case int:
+		return float64(vcast), true
+	case int64:
+		return float64(vcast), true
+	}
+	return 0, false
+}
+
+// validateChainRater casts the raters option of a chain rater to a list of rater names.  Invalid chains
+// don't change rates.
+func validateChainRater(r *RaterConfig) {
+	operation, _ := r.Options["operation"].(string)
+	if operation != "" && operation != "multiply" && operation != "add" {
+		log.Errorf("Operation must be 'multiply' or 'add' for chain rater '%s'", r.Name)
+		delete(r.Options, "raters")
+		return
+	}
+	var names []string
+	switch raters := r.Options["raters"].(type) {
+	case []string:
+		names = raters
+	case []interface{}:
+		for _, v := range raters {
+			name, ok := v.(string)
+			if !ok {
+				log.Errorf("Rater '%#v' in chain rater '%s' is not a rater name", v, r.Name)
+				delete(r.Options, "raters")
+				return
+			}
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		log.Errorf("No raters set for chain rater '%s'", r.Name)
+		delete(r.Options, "raters")
+		return
+	}
+	r.Options["raters"] = names
+}
+
+// setupCalendar parses the Holidays and DateRanges of a config rater.  Invalid entries are skipped.
+func (c *Config) setupCalendar(r *RaterConfig) {
+	r.calendar = nil
+	for _, k := range []string{"Holidays", "DateRanges"} {
+		var entries []interface{}
+		switch v := r.Options[k].(type) {
+		case nil:
+		case []interface{}:
+			entries = v
+		case []map[string]interface{}:
+			for _, e := range v {
+				entries = append(entries, e)
+			}
+		case []map[interface{}]interface{}:
+			for _, e := range v {
+				entries = append(entries, e)
+			}
+		default:
+			log.Errorf("%s must be a list for rater '%s'", k, r.Name)
+			continue
+		}
+		for i, e := range entries {
+			cd, err := parseCalendarEntry(k == "Holidays", e)
+			if err != nil {
+				log.Errorf("Invalid entry %d in %s for rater '%s', skipping entry: %s", i, k, r.Name, err)
+				continue
+			}
+			r.calendar = append(r.calendar, cd)
+		}
+	}
+}
+
+// setupTimeSeries loads the CSV for a timeseries rater.  If it can't be loaded, the rater doesn't change rates.
+func (c *Config) setupTimeSeries(r *RaterConfig) {
+	file, _ := r.Options["file"].(string)
+	if file == "" {
+		log.Errorf("No file set for timeseries rater '%s'", r.Name)
+		return
+	}
+	interpolation, _ := r.Options["interpolation"].(string)
+	if interpolation != "" && interpolation != "linear" && interpolation != "step" {
+		log.Errorf("Interpolation must be 'linear' or 'step' for timeseries rater '%s'", r.Name)
+		return
+	}
+	ts, err := LoadTimeSeries(c.findFile(file), interpolation == "step")
+	if err != nil {
+		log.Errorf("Error loading time series for rater '%s': %s", r.Name, err)
+		return
+	}
+	r.TimeSeries = ts
 }
@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// maxDrawTries bounds how many values we'll draw from a distribution looking for one between Lower and Upper
+const maxDrawTries = 100
+
+// minDrawMass is the least share of a distribution which must fall between Lower and Upper, so draws rarely
+// fall back to uniform
+const minDrawMass = 0.05
+
+// distributionParams lists the parameters each distribution type takes
+var distributionParams = map[string][]string{
+	"normal":      {"mean", "stdDev"},
+	"lognormal":   {"mean", "stdDev"},
+	"exponential": {"lambda"},
+	"poisson":     {"lambda"},
+	"zipf":        {"s", "v"},
+	"pareto":      {"alpha", "scale"},
+}
+
+// Distribution describes a non-uniform distribution to draw `random` and `rated` int and float tokens from.
+// Draws are truncated to the token's Lower and Upper bounds, including Lower and excluding Upper.
+type Distribution struct {
+	Type   string  `json:"type" yaml:"type"`
+	Mean   float64 `json:"mean,omitempty" yaml:"mean,omitempty"`
+	StdDev float64 `json:"stdDev,omitempty" yaml:"stdDev,omitempty"`
+	Lambda float64 `json:"lambda,omitempty" yaml:"lambda,omitempty"`
+	S      float64 `json:"s,omitempty" yaml:"s,omitempty"`
+	V      float64 `json:"v,omitempty" yaml:"v,omitempty"`
+	Alpha  float64 `json:"alpha,omitempty" yaml:"alpha,omitempty"`
+	Scale  float64 `json:"scale,omitempty" yaml:"scale,omitempty"`
+	zipf   *zipf
+}
+
+// zipf holds the constants for drawing from a zipf distribution with Go's rand.Zipf algorithm, so they're
+// computed once per token rather than on every draw
+type zipf struct {
+	imax, v, q, s, oneminusQ, oneminusQinv, hxm, hx0minusHxm float64
+}
+
+// validate checks the parameters required by each distribution type, and that it isn't given parameters of
+// other types
+func (d *Distribution) validate() error {
+	allowed, ok := distributionParams[d.Type]
+	if !ok {
+		return fmt.Errorf("unknown distribution type '%s'", d.Type)
+	}
+	params := map[string]float64{"mean": d.Mean, "stdDev": d.StdDev, "lambda": d.Lambda, "s": d.S, "v": d.V, "alpha": d.Alpha, "scale": d.Scale}
+	for _, name := range allowed {
+		if math.IsNaN(params[name]) || math.IsInf(params[name], 0) {
+			return fmt.Errorf("%s must be a finite number for %s distribution", name, d.Type)
+		}
+		delete(params, name)
+	}
+	for name, v := range params {
+		if v != 0 {
+			return fmt.Errorf("%s is not a parameter of %s distribution", name, d.Type)
+		}
+	}
+	switch d.Type {
+	case "normal", "lognormal":
+		if d.StdDev <= 0 {
+			return fmt.Errorf("stdDev must be greater than zero for %s distribution", d.Type)
+		}
+	case "exponential", "poisson":
+		if d.Lambda <= 0 {
+			return fmt.Errorf("lambda must be greater than zero for %s distribution", d.Type)
+		}
+	case "zipf":
+		if d.S <= 1 {
+			return fmt.Errorf("s must be greater than one for zipf distribution")
+		}
+		if d.V < 1 {
+			return fmt.Errorf("v must be greater than or equal to one for zipf distribution")
+		}
+	case "pareto":
+		if d.Alpha <= 0 {
+			return fmt.Errorf("alpha must be greater than zero for pareto distribution")
+		}
+		if d.Scale <= 0 {
+			return fmt.Errorf("scale must be greater than zero for pareto distribution")
+		}
+	}
+	return nil
+}
+
+// setup validates the distribution and prepares it for drawing between lower and upper.  Distributions
+// which rarely fall between lower and upper are rejected, rather than drawing uniformly instead.
+func (d *Distribution) setup(lower int, upper int) error {
+	if err := d.validate(); err != nil {
+		return err
+	}
+	if m := d.mass(float64(lower), float64(upper)); m < minDrawMass {
+		return fmt.Errorf("only %.2g%% of the %s distribution is between lower and upper", m*100, d.Type)
+	}
+	if d.Type == "zipf" {
+		d.zipf = newZipf(d.S, d.V, float64(lower), float64(upper))
+	}
+	return nil
+}
+
+// draw returns a value from the distribution between lower and upper, drawing again when a value falls
+// outside.  If none falls inside after maxDrawTries, a value is drawn uniformly instead.
+func (d *Distribution) draw(randgen *rand.Rand, lower float64, upper float64) float64 {
+	if upper <= lower {
+		return lower
+	}
+	for i := 0; i < maxDrawTries; i++ {
+		if v := d.sample(randgen, lower, upper); v >= lower && v < upper {
+			return v
+		}
+	}
+	return lower + randgen.Float64()*(upper-lower)
+}
+
+// drawInt returns a value from the distribution rounded to an integer between lower and upper, like draw
+func (d *Distribution) drawInt(randgen *rand.Rand, lower int, upper int) int {
+	if upper <= lower {
+		return lower
+	}
+	for i := 0; i < maxDrawTries; i++ {
+		if v := int(math.Round(d.sample(randgen, float64(lower), float64(upper)))); v >= lower && v < upper {
+			return v
+		}
+	}
+	return randgen.Intn(upper-lower) + lower
+}
+
+// sample returns an untruncated value from the distribution.  Zipf draws are offset from lower, as zipf
+// only produces integers counting up from zero.
+func (d *Distribution) sample(randgen *rand.Rand, lower float64, upper float64) float64 {
+	var v float64
+	switch d.Type {
+	case "normal":
+		v = randgen.NormFloat64()*d.StdDev + d.Mean
+	case "lognormal":
+		v = math.Exp(randgen.NormFloat64()*d.StdDev + d.Mean)
+	case "exponential":
+		v = randgen.ExpFloat64() / d.Lambda
+	case "poisson":
+		v = float64(poisson(randgen, d.Lambda))
+	case "zipf":
+		z := d.zipf
+		if z == nil {
+			z = newZipf(d.S, d.V, lower, upper)
+		}
+		v = lower + z.draw(randgen)
+	case "pareto":
+		v = d.Scale / math.Pow(1-randgen.Float64(), 1/d.Alpha)
+	}
+	return v
+}
+
+// mass returns the share of the distribution between lower and upper
+func (d *Distribution) mass(lower float64, upper float64) float64 {
+	normal := func(x float64) float64 {
+		return 0.5 * math.Erfc(-x/math.Sqrt2)
+	}
+	var cdf func(x float64) float64
+	switch d.Type {
+	case "normal":
+		cdf = func(x float64) float64 { return normal((x - d.Mean) / d.StdDev) }
+	case "lognormal":
+		cdf = func(x float64) float64 {
+			if x <= 0 {
+				return 0
+			}
+			return normal((math.Log(x) - d.Mean) / d.StdDev)
+		}
+	case "exponential":
+		cdf = func(x float64) float64 { return 1 - math.Exp(-d.Lambda*math.Max(x, 0)) }
+	case "pareto":
+		cdf = func(x float64) float64 {
+			if x < d.Scale {
+				return 0
+			}
+			return 1 - math.Pow(d.Scale/x, d.Alpha)
+		}
+	case "poisson":
+		// Past this many standard deviations above the mean, the rest of the sum is negligible
+		last := math.Min(math.Ceil(upper)-1, math.Ceil(d.Lambda+20*math.Sqrt(d.Lambda)+20))
+		var m float64
+		for k := math.Max(math.Ceil(lower), 0); k <= last; k++ {
+			lg, _ := math.Lgamma(k + 1)
+			m += math.Exp(k*math.Log(d.Lambda) - d.Lambda - lg)
+		}
+		return m
+	default:
+		// Zipf draws count up from lower and never exceed upper
+		return 1
+	}
+	return cdf(upper) - cdf(lower)
+}
+
+// newZipf returns a zipf distribution counting up from zero, for drawing integers between lower and upper
+func newZipf(s float64, v float64, lower float64, upper float64) *zipf {
+	z := &zipf{imax: math.Max(math.Ceil(upper-lower)-1, 0), v: v, q: s}
+	z.oneminusQ = 1.0 - z.q
+	z.oneminusQinv = 1.0 / z.oneminusQ
+	z.hxm = z.h(z.imax + 0.5)
+	z.hx0minusHxm = z.h(0.5) - math.Exp(math.Log(z.v)*(-z.q)) - z.hxm
+	z.s = 1 - z.hinv(z.h(1.5)-math.Exp(-z.q*math.Log(z.v+1.0)))
+	return z
+}
+
+func (z *zipf) h(x float64) float64 {
+	return math.Exp(z.oneminusQ*math.Log(z.v+x)) * z.oneminusQinv
+}
+
+func (z *zipf) hinv(x float64) float64 {
+	return math.Exp(z.oneminusQinv*math.Log(z.oneminusQ*x)) - z.v
+}
+
+// draw returns a value in [0, imax] by rejection-inversion, as in rand.Zipf
+func (z *zipf) draw(randgen *rand.Rand) float64 {
+	for {
+		ur := z.hxm + randgen.Float64()*z.hx0minusHxm
+		x := z.hinv(ur)
+		k := math.Floor(x + 0.5)
+		if k-x <= z.s || ur >= z.h(k+0.5)-math.Exp(-math.Log(k+z.v)*z.q) {
+			return k
+		}
+	}
+}
+
+// poisson draws from a poisson distribution with mean lambda.  Small means use Knuth's
+// multiplication method, larger means use Hörmann's transformed rejection (PTRS).
+func poisson(randgen *rand.Rand, lambda float64) int64 {
+	if lambda < 10 {
+		l := math.Exp(-lambda)
+		k := int64(0)
+		p := randgen.Float64()
+		for p > l {
+			k++
+			p *= randgen.Float64()
+		}
+		return k
+	}
+	slam := math.Sqrt(lambda)
+	loglam := math.Log(lambda)
+	b := 0.931 + 2.53*slam
+	a := -0.059 + 0.02483*b
+	invalpha := 1.1239 + 1.1328/(b-3.4)
+	vr := 0.9277 - 3.6224/(b-2)
+	for {
+		u := randgen.Float64() - 0.5
+		v := randgen.Float64()
+		us := 0.5 - math.Abs(u)
+		k := math.Floor((2*a/us+b)*u + lambda + 0.43)
+		if us >= 0.07 && v <= vr {
+			return int64(k)
+		}
+		if k < 0 || (us < 0.013 && v > us) {
+			continue
+		}
+		lg, _ := math.Lgamma(k + 1)
+		if math.Log(v)+math.Log(invalpha)-math.Log(a/(us*us)+b) <= -lambda+k*loglam-lg {
+			return int64(k)
+		}
+	}
+}
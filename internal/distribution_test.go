@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistributionValidate(t *testing.T) {
+	valid := []Distribution{
+		{Type: "normal", Mean: 10, StdDev: 2},
+		{Type: "lognormal", Mean: 1, StdDev: 0.5},
+		{Type: "exponential", Lambda: 0.5},
+		{Type: "poisson", Lambda: 4},
+		{Type: "zipf", S: 1.1, V: 1},
+		{Type: "pareto", Alpha: 1.5, Scale: 1},
+	}
+	for _, d := range valid {
+		assert.NoError(t, d.validate(), d.Type)
+	}
+	invalid := []Distribution{
+		{Type: "normal", Mean: 10},
+		{Type: "lognormal"},
+		{Type: "exponential"},
+		{Type: "poisson", Lambda: -1},
+		{Type: "zipf", S: 1, V: 1},
+		{Type: "zipf", S: 2, V: 0},
+		{Type: "pareto", Alpha: 1},
+		{Type: "pareto", Scale: 1},
+		{Type: "normal", Mean: 10, StdDev: 2, Lambda: 1},
+		{Type: "poisson", Lambda: 4, S: 2},
+		{Type: "normal", Mean: math.NaN(), StdDev: 2},
+		{Type: "bogus"},
+	}
+	for _, d := range invalid {
+		assert.Error(t, d.validate(), "%#v", d)
+	}
+}
+
+func TestDistributionSetup(t *testing.T) {
+	// Distributions which rarely fall between lower and upper would be drawn uniformly, so they're rejected
+	valid := []struct {
+		d            Distribution
+		lower, upper int
+	}{
+		{Distribution{Type: "normal", Mean: 50, StdDev: 20}, 30, 70},
+		{Distribution{Type: "lognormal", Mean: 4, StdDev: 0.5}, 0, 1000},
+		{Distribution{Type: "exponential", Lambda: 0.1}, 0, 10},
+		{Distribution{Type: "poisson", Lambda: 4}, 0, 10},
+		{Distribution{Type: "zipf", S: 2, V: 1}, 100, 200},
+		{Distribution{Type: "pareto", Alpha: 3, Scale: 2}, 0, 100},
+	}
+	for _, v := range valid {
+		assert.NoError(t, v.d.setup(v.lower, v.upper), "%#v", v)
+	}
+	invalid := []struct {
+		d            Distribution
+		lower, upper int
+	}{
+		{Distribution{Type: "normal", Mean: 1000, StdDev: 1}, 0, 50},
+		{Distribution{Type: "lognormal", Mean: 10, StdDev: 0.5}, 0, 1000},
+		{Distribution{Type: "exponential", Lambda: 1}, 100, 200},
+		{Distribution{Type: "poisson", Lambda: 4}, 100, 200},
+		{Distribution{Type: "pareto", Alpha: 3, Scale: 200}, 0, 100},
+	}
+	for _, v := range invalid {
+		assert.Error(t, v.d.setup(v.lower, v.upper), "%#v", v)
+	}
+}
+
+func TestDistributionDraw(t *testing.T) {
+	randgen := rand.New(rand.NewSource(0))
+	mean := func(d *Distribution, lower, upper float64) float64 {
+		sum := 0.0
+		for i := 0; i < 10000; i++ {
+			v := d.draw(randgen, lower, upper)
+			assert.True(t, v >= lower && v < upper, "%s draw %f out of bounds", d.Type, v)
+			sum += v
+		}
+		return sum / 10000
+	}
+	assert.InDelta(t, 100, mean(&Distribution{Type: "normal", Mean: 100, StdDev: 10}, 0, 1000), 1)
+	assert.InDelta(t, 10, mean(&Distribution{Type: "exponential", Lambda: 0.1}, 0, 100000), 0.5)
+	assert.InDelta(t, 4, mean(&Distribution{Type: "poisson", Lambda: 4}, 0, 100000), 0.1)
+	assert.InDelta(t, 200, mean(&Distribution{Type: "poisson", Lambda: 200}, 0, 100000), 1)
+	assert.InDelta(t, 3, mean(&Distribution{Type: "pareto", Alpha: 3, Scale: 2}, 0, 100000), 0.1)
+	assert.InDelta(t, 50, mean(&Distribution{Type: "normal", Mean: 50, StdDev: 20}, 30, 70), 0.5)
+	// Values which never fall between the bounds are drawn uniformly instead
+	assert.InDelta(t, 25, mean(&Distribution{Type: "normal", Mean: 1000, StdDev: 1}, 0, 50), 1)
+	zipf := &Distribution{Type: "zipf", S: 2, V: 1}
+	assert.NoError(t, zipf.setup(10, 1000))
+	assert.Less(t, mean(zipf, 10, 1000), 20.0)
+}
+
+func TestDistributionTruncated(t *testing.T) {
+	randgen := rand.New(rand.NewSource(0))
+	// Truncating rather than clamping doesn't pile values up at the bounds
+	d := &Distribution{Type: "normal", Mean: 50, StdDev: 20}
+	edges := 0
+	for i := 0; i < 10000; i++ {
+		if v := d.draw(randgen, 30, 70); v < 31 || v >= 69 {
+			edges++
+		}
+	}
+	assert.Less(t, edges, 1000)
+
+	d = &Distribution{Type: "poisson", Lambda: 4}
+	counts := make(map[int]int)
+	for i := 0; i < 10000; i++ {
+		counts[d.drawInt(randgen, 2, 5)]++
+	}
+	assert.Len(t, counts, 3)
+	assert.Zero(t, counts[5])
+
+	d = &Distribution{Type: "zipf", S: 1.5, V: 1}
+	assert.NoError(t, d.setup(0, 3))
+	counts = make(map[int]int)
+	for i := 0; i < 10000; i++ {
+		counts[d.drawInt(randgen, 0, 3)]++
+	}
+	assert.Len(t, counts, 3)
+	assert.Greater(t, counts[0], counts[1])
+	assert.Greater(t, counts[1], counts[2])
+}
+
+func TestGenReplacementDistribution(t *testing.T) {
+	randgen := rand.New(rand.NewSource(0))
+	now := time.Now()
+	token := Token{
+		Name:         "latency",
+		Type:         "random",
+		Replacement:  "int",
+		Lower:        0,
+		Upper:        1000,
+		Distribution: &Distribution{Type: "lognormal", Mean: 4, StdDev: 0.5},
+	}
+	for i := 0; i < 100; i++ {
+		r, _, err := token.GenReplacement(-1, now, now, now, randgen, map[string]string{})
+		assert.NoError(t, err)
+		v, err := strconv.Atoi(r)
+		assert.NoError(t, err)
+		assert.True(t, v >= 0 && v <= 1000)
+	}
+
+	token.Replacement = "float"
+	token.Precision = 2
+	token.Type = "rated"
+	token.Rater = &mockRater{rate: 2.0}
+	token.Distribution = &Distribution{Type: "normal", Mean: 10, StdDev: 0.001}
+	r, _, err := token.GenReplacement(-1, now, now, now, randgen, map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, "20.00", r)
+}
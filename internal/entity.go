@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"math/rand"
+	"time"
+
+	log "github.com/coccyx/gogen/logger"
+)
+
+// EntityConfig describes a pool of entities, like users, hosts or devices, whose attributes are generated
+// once and stay fixed for the whole run.  Entity tokens in any sample draw from the pool, so the same
+// entity always carries the same attributes across events and samples.
+type EntityConfig struct {
+	Name       string  `json:"name" yaml:"name"`
+	Count      int     `json:"count,omitempty" yaml:"count,omitempty"`
+	Sample     string  `json:"sample,omitempty" yaml:"sample,omitempty"`
+	Attributes []Token `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+
+	// Internal use variables
+	Entities []map[string]string `json:"-" yaml:"-"`
+	lines    []map[string]string
+	valid    bool
+}
+
+// FindEntity returns an EntityConfig matched by the passed name
+func (c *Config) FindEntity(name string) *EntityConfig {
+	for _, e := range c.Entities {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// validateEntity resolves an entity pool's sample and attribute tokens.  Attributes are validated like
+// the tokens of a sample, so they support every token type and can reference other samples.
+func (c *Config) validateEntity(e *EntityConfig) {
+	e.valid = false
+	if e.Sample != "" {
+		s := c.FindSampleByName(e.Sample)
+		if s == nil || len(s.Lines) == 0 {
+			log.Errorf("Sample '%s' not found or empty for entity '%s', disabling entity", e.Sample, e.Name)
+			return
+		}
+		e.lines = s.Lines
+		setDefault(&e.Count, len(e.lines))
+	}
+	if e.Count <= 0 {
+		log.Errorf("Count must be greater than zero for entity '%s', disabling entity", e.Name)
+		return
+	}
+	es := &Sample{Name: "entity:" + e.Name, Tokens: e.Attributes}
+	c.resolveTokenSamples(es)
+	c.validateTokens(es)
+	c.orderTokens(es)
+	if es.Disabled {
+		log.Errorf("Invalid attributes for entity '%s', disabling entity", e.Name)
+		return
+	}
+	// Attributes are generated in order, so put expressions after the attributes they reference
+	if es.TokenOrder != nil {
+		e.Attributes = make([]Token, 0, len(es.Tokens))
+		for _, i := range es.TokenOrder {
+			e.Attributes = append(e.Attributes, es.Tokens[i])
+		}
+	} else {
+		e.Attributes = es.Tokens
+	}
+	e.valid = true
+}
+
+// hasAttribute returns whether every entity in the pool will carry the attribute name
+func (e *EntityConfig) hasAttribute(name string) bool {
+	for _, t := range e.Attributes {
+		if t.Name == name {
+			return true
+		}
+	}
+	if len(e.lines) > 0 {
+		_, ok := e.lines[0][name]
+		return ok
+	}
+	return false
+}
+
+// generate builds the pool of entities.  Rows from Sample are reused if Count exceeds them,
+// and attributes are generated on top of them in order, honoring token groups.
+func (e *EntityConfig) generate(seed int64) {
+	if !e.valid {
+		return
+	}
+	var randgen *rand.Rand
+	if seed != 0 {
+		randgen = NewSeededRand(seed, "entity:"+e.Name)
+	} else {
+		randgen = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	now := time.Now()
+	entities := make([]map[string]string, e.Count)
+	for i := 0; i < e.Count; i++ {
+		entity := make(map[string]string, len(e.Attributes))
+		if len(e.lines) > 0 {
+			for k, v := range e.lines[i%len(e.lines)] {
+				entity[k] = v
+			}
+		}
+		choices := make(map[int]int)
+		for _, t := range e.Attributes {
+			choice := -1
+			if c, ok := choices[t.Group]; ok && t.Group > 0 {
+				choice = c
+			}
+			replacement, choice, err := t.GenReplacement(choice, now, now, now, randgen, entity)
+			if err != nil {
+				log.Errorf("Error generating attribute '%s' for entity '%s': %s", t.Name, e.Name, err)
+			}
+			entity[t.Name] = replacement
+			if t.Group > 0 {
+				choices[t.Group] = choice
+			}
+		}
+		entities[i] = entity
+	}
+	e.Entities = entities
+}
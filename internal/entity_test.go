@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntity(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join("..", "tests", "entity", "entity.yml"))
+	defer os.Unsetenv("GOGEN_FULLCONFIG")
+
+	c := NewConfig()
+	e := c.FindEntity("users")
+	assert.NotNil(t, e)
+	assert.Len(t, e.Entities, 20)
+	for _, entity := range e.Entities {
+		assert.Len(t, entity["user"], 8)
+		assert.Regexp(t, `^(10|172|192)\.`, entity["ip"])
+		if entity["department"] == "sales" {
+			assert.Equal(t, "US", entity["country"])
+		} else {
+			assert.Equal(t, "DE", entity["country"])
+		}
+	}
+	assert.Nil(t, c.FindSampleByName("badentity"))
+
+	// Every sample sees the same user with the same attributes
+	ips := make(map[string]string)
+	re := regexp.MustCompile(`user=(\S+) src=(\S+)`)
+	n := time.Now()
+	for _, name := range []string{"logins", "proxy"} {
+		s := c.FindSampleByName(name)
+		randgen := rand.New(rand.NewSource(0))
+		for i := 0; i < 50; i++ {
+			event := s.Lines[0]["_raw"]
+			choices := make(map[int]int)
+			for _, tok := range s.Tokens {
+				choice := -1
+				if ch, ok := choices[tok.Group]; ok {
+					choice = ch
+				}
+				choice, err := tok.Replace(&event, choice, n, n, n, randgen, map[string]string{})
+				assert.NoError(t, err)
+				choices[tok.Group] = choice
+			}
+			m := re.FindStringSubmatch(event)
+			assert.Len(t, m, 3, event)
+			if ip, ok := ips[m[1]]; ok {
+				assert.Equal(t, ip, m[2])
+			}
+			ips[m[1]] = m[2]
+		}
+	}
+
+	// Pools are reproducible from the seed
+	first := e.Entities
+	c.SetSeed(42)
+	assert.Equal(t, first, e.Entities)
+	c.SetSeed(43)
+	assert.NotEqual(t, first, e.Entities)
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// epochUnits maps the replacement of an epochtimestamp token to the unit it counts in
+var epochUnits = map[string]time.Duration{
+	"":        time.Second,
+	"seconds": time.Second,
+	"millis":  time.Millisecond,
+	"micros":  time.Microsecond,
+	"nanos":   time.Nanosecond,
+}
+
+// epochFractionDigits returns how many fractional digits an epoch unit can carry down to nanoseconds
+func epochFractionDigits(unit time.Duration) int {
+	return len(strconv.FormatInt(int64(unit), 10)) - 1
+}
+
+// validateEpoch checks the unit and precision of an epochtimestamp token
+func (t Token) validateEpoch() error {
+	unit, ok := epochUnits[t.Replacement]
+	if !ok {
+		return fmt.Errorf("replacement must be one of seconds, millis, micros or nanos, got '%s'", t.Replacement)
+	}
+	if t.Precision < 0 || t.Precision > epochFractionDigits(unit) {
+		return fmt.Errorf("precision must be between 0 and %d for %s", epochFractionDigits(unit), t.Replacement)
+	}
+	return nil
+}
+
+// formatEpoch formats ts as a count of the token's unit since the epoch, with Precision fractional digits.
+// Before the epoch the fraction has the same sign as the whole part, so -4.75s is -4.750, and extra digits
+// are truncated towards zero.
+func (t Token) formatEpoch(ts time.Time) string {
+	unit := int64(epochUnits[t.Replacement])
+	ns := ts.UnixNano()
+	neg := ns < 0
+	if neg {
+		ns = -ns
+	}
+	ret := strconv.FormatInt(ns/unit, 10)
+	if t.Precision > 0 {
+		// Adding unit keeps the leading zeros of the fraction, the leading 1 is dropped
+		frac := strconv.FormatInt(ns%unit+unit, 10)
+		ret += "." + frac[1:1+t.Precision]
+	}
+	// Don't write -0 when everything before the epoch was truncated
+	if neg && strings.Trim(ret, "0.") != "" {
+		ret = "-" + ret
+	}
+	return ret
+}
+
+// parseEpoch parses a count of the token's unit since the epoch, with an optional fraction which has the
+// same sign as the whole part
+func (t Token) parseEpoch(eventts string) (time.Time, error) {
+	unit, ok := epochUnits[t.Replacement]
+	if !ok {
+		return time.Time{}, fmt.Errorf("Invalid epoch unit '%s'", t.Replacement)
+	}
+	whole, frac, _ := strings.Cut(eventts, ".")
+	tsi, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var fracns int64
+	if frac != "" {
+		digits := epochFractionDigits(unit)
+		if len(frac) > digits {
+			frac = frac[:digits]
+		} else {
+			frac += strings.Repeat("0", digits-len(frac))
+		}
+		if fracns, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if strings.HasPrefix(whole, "-") {
+		fracns = -fracns
+	}
+	if unit == time.Second {
+		return time.Unix(tsi, fracns), nil
+	}
+	return time.Unix(0, tsi*int64(unit)+fracns), nil
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEpochTimestamp(t *testing.T) {
+	ts := time.Unix(1697500000, 123456789)
+	tests := []struct {
+		unit      string
+		precision int
+		expected  string
+	}{
+		{"", 0, "1697500000"},
+		{"seconds", 3, "1697500000.123"},
+		{"seconds", 9, "1697500000.123456789"},
+		{"millis", 0, "1697500000123"},
+		{"millis", 2, "1697500000123.45"},
+		{"micros", 0, "1697500000123456"},
+		{"nanos", 0, "1697500000123456789"},
+	}
+	for _, tc := range tests {
+		tok := Token{Type: "epochtimestamp", Replacement: tc.unit, Precision: tc.precision}
+		assert.NoError(t, tok.validateEpoch())
+		assert.Equal(t, tc.expected, tok.formatEpoch(ts), "%s %d", tc.unit, tc.precision)
+		parsed, err := tok.ParseTimestamp(tc.expected)
+		assert.NoError(t, err)
+		assert.Equal(t, ts.Unix(), parsed.Unix())
+		assert.True(t, ts.Sub(parsed) < time.Second && ts.Sub(parsed) >= 0)
+	}
+
+	// Leading zeros in the fraction are kept
+	tok := Token{Type: "epochtimestamp", Replacement: "seconds", Precision: 3}
+	assert.Equal(t, "1697500000.005", tok.formatEpoch(time.Unix(1697500000, 5000000)))
+	parsed, err := tok.ParseTimestamp("1697500000.005")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1697500000, 5000000), parsed)
+	parsed, err = Token{Type: "epochtimestamp", Replacement: "millis"}.ParseTimestamp("1697500000123.5")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1697500000, 123500000), parsed)
+
+	// Before the epoch, the fraction has the same sign as the whole part
+	pre := time.Unix(-5, 250000000)
+	assert.Equal(t, "-4.750", tok.formatEpoch(pre))
+	parsed, err = tok.ParseTimestamp("-4.750")
+	assert.NoError(t, err)
+	assert.Equal(t, pre, parsed)
+	parsed, err = tok.ParseTimestamp("-4.25")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(-5, 750000000), parsed)
+	assert.Equal(t, "-4", Token{Replacement: "seconds"}.formatEpoch(pre))
+	assert.Equal(t, "-6", Token{Replacement: "seconds"}.formatEpoch(time.Unix(-6, 0)))
+	assert.Equal(t, "-4750.000", Token{Replacement: "millis", Precision: 3}.formatEpoch(pre))
+	parsed, err = Token{Type: "epochtimestamp", Replacement: "millis"}.ParseTimestamp("-4750.5")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(-5, 249500000), parsed)
+	micros := Token{Type: "epochtimestamp", Replacement: "micros", Precision: 3}
+	assert.Equal(t, "-0.001", micros.formatEpoch(time.Unix(0, -1)))
+	parsed, err = micros.ParseTimestamp("-0.001")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(0, -1), parsed)
+	assert.Equal(t, "0", Token{Replacement: "seconds"}.formatEpoch(time.Unix(0, -1)))
+
+	_, err = tok.ParseTimestamp("notanumber")
+	assert.Error(t, err)
+	assert.Error(t, Token{Replacement: "hours"}.validateEpoch())
+	assert.Error(t, Token{Replacement: "nanos", Precision: 1}.validateEpoch())
+	assert.Error(t, Token{Replacement: "seconds", Precision: 10}.validateEpoch())
+}
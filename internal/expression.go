@@ -0,0 +1,514 @@
+package internal
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// exprNode is a node in a parsed expression tree
+type exprNode interface {
+	eval(lookup func(string) string) (exprValue, error)
+}
+
+// exprValue is the result of evaluating an expression.  Values from tokens and fields are strings,
+// and are treated as numbers whenever they parse as one.
+type exprValue struct {
+	s     string
+	f     float64
+	isNum bool
+}
+
+func strValue(s string) exprValue { return exprValue{s: s} }
+
+func numValue(f float64) exprValue { return exprValue{f: f, isNum: true} }
+
+func boolValue(b bool) exprValue {
+	if b {
+		return numValue(1)
+	}
+	return numValue(0)
+}
+
+func (v exprValue) num() (float64, bool) {
+	if v.isNum {
+		return v.f, true
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v.s), 64)
+	return f, err == nil
+}
+
+func (v exprValue) str() string {
+	if v.isNum {
+		return strconv.FormatFloat(v.f, 'f', -1, 64)
+	}
+	return v.s
+}
+
+func (v exprValue) truthy() bool {
+	if f, ok := v.num(); ok {
+		return f != 0
+	}
+	return v.s != "" && v.s != "false"
+}
+
+type exprLiteral struct{ v exprValue }
+
+type exprIdent struct{ name string }
+
+type exprUnary struct {
+	op string
+	x  exprNode
+}
+
+type exprBinary struct {
+	op   string
+	l, r exprNode
+}
+
+type exprCall struct {
+	name string
+	args []exprNode
+}
+
+func (e exprLiteral) eval(lookup func(string) string) (exprValue, error) { return e.v, nil }
+
+func (e exprIdent) eval(lookup func(string) string) (exprValue, error) {
+	return strValue(lookup(e.name)), nil
+}
+
+func (e exprUnary) eval(lookup func(string) string) (exprValue, error) {
+	x, err := e.x.eval(lookup)
+	if err != nil {
+		return x, err
+	}
+	if e.op == "!" {
+		return boolValue(!x.truthy()), nil
+	}
+	f, ok := x.num()
+	if !ok {
+		return x, fmt.Errorf("cannot negate non-numeric value '%s'", x.str())
+	}
+	return numValue(-f), nil
+}
+
+func (e exprBinary) eval(lookup func(string) string) (exprValue, error) {
+	l, err := e.l.eval(lookup)
+	if err != nil {
+		return l, err
+	}
+	// Short circuit logical operators
+	switch e.op {
+	case "&&":
+		if !l.truthy() {
+			return boolValue(false), nil
+		}
+		r, err := e.r.eval(lookup)
+		return boolValue(r.truthy()), err
+	case "||":
+		if l.truthy() {
+			return boolValue(true), nil
+		}
+		r, err := e.r.eval(lookup)
+		return boolValue(r.truthy()), err
+	}
+	r, err := e.r.eval(lookup)
+	if err != nil {
+		return r, err
+	}
+	lf, lok := l.num()
+	rf, rok := r.num()
+	bothNum := lok && rok
+	switch e.op {
+	case "+":
+		if !bothNum {
+			return strValue(l.str() + r.str()), nil
+		}
+		return numValue(lf + rf), nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		var c int
+		if bothNum {
+			c = compareFloat(lf, rf)
+		} else {
+			c = strings.Compare(l.str(), r.str())
+		}
+		switch e.op {
+		case "==":
+			return boolValue(c == 0), nil
+		case "!=":
+			return boolValue(c != 0), nil
+		case "<":
+			return boolValue(c < 0), nil
+		case "<=":
+			return boolValue(c <= 0), nil
+		case ">":
+			return boolValue(c > 0), nil
+		default:
+			return boolValue(c >= 0), nil
+		}
+	}
+	if !bothNum {
+		return l, fmt.Errorf("operator '%s' requires numbers, got '%s' and '%s'", e.op, l.str(), r.str())
+	}
+	switch e.op {
+	case "-":
+		return numValue(lf - rf), nil
+	case "*":
+		return numValue(lf * rf), nil
+	case "/":
+		if rf == 0 {
+			return l, fmt.Errorf("division by zero")
+		}
+		return numValue(lf / rf), nil
+	case "%":
+		if rf == 0 {
+			return l, fmt.Errorf("division by zero")
+		}
+		return numValue(math.Mod(lf, rf)), nil
+	}
+	return l, fmt.Errorf("unknown operator '%s'", e.op)
+}
+
+func compareFloat(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// exprFuncs maps function names to their implementation and the number of arguments they take, -1 for any
+var exprFuncs = map[string]struct {
+	nargs int
+	f     func(args []exprValue) (exprValue, error)
+}{
+	"lower": {1, func(a []exprValue) (exprValue, error) { return strValue(strings.ToLower(a[0].str())), nil }},
+	"upper": {1, func(a []exprValue) (exprValue, error) { return strValue(strings.ToUpper(a[0].str())), nil }},
+	"trim":  {1, func(a []exprValue) (exprValue, error) { return strValue(strings.TrimSpace(a[0].str())), nil }},
+	"len":   {1, func(a []exprValue) (exprValue, error) { return numValue(float64(len(a[0].str()))), nil }},
+	"md5": {1, func(a []exprValue) (exprValue, error) {
+		sum := md5.Sum([]byte(a[0].str()))
+		return strValue(hex.EncodeToString(sum[:])), nil
+	}},
+	"sha1": {1, func(a []exprValue) (exprValue, error) {
+		sum := sha1.Sum([]byte(a[0].str()))
+		return strValue(hex.EncodeToString(sum[:])), nil
+	}},
+	"sha256": {1, func(a []exprValue) (exprValue, error) {
+		sum := sha256.Sum256([]byte(a[0].str()))
+		return strValue(hex.EncodeToString(sum[:])), nil
+	}},
+	"base64": {1, func(a []exprValue) (exprValue, error) {
+		return strValue(base64.StdEncoding.EncodeToString([]byte(a[0].str()))), nil
+	}},
+	"replace": {3, func(a []exprValue) (exprValue, error) {
+		return strValue(strings.ReplaceAll(a[0].str(), a[1].str(), a[2].str())), nil
+	}},
+	"substr": {3, func(a []exprValue) (exprValue, error) {
+		s := a[0].str()
+		start, ok1 := a[1].num()
+		length, ok2 := a[2].num()
+		if !ok1 || !ok2 {
+			return a[0], fmt.Errorf("substr requires numeric start and length")
+		}
+		b := int(math.Max(0, math.Min(start, float64(len(s)))))
+		e := int(math.Max(float64(b), math.Min(start+length, float64(len(s)))))
+		return strValue(s[b:e]), nil
+	}},
+	"concat": {-1, func(a []exprValue) (exprValue, error) {
+		var b strings.Builder
+		for _, v := range a {
+			b.WriteString(v.str())
+		}
+		return strValue(b.String()), nil
+	}},
+	"if": {3, nil}, // Evaluated lazily in exprCall.eval
+	"round": {2, func(a []exprValue) (exprValue, error) {
+		f, ok1 := a[0].num()
+		p, ok2 := a[1].num()
+		if !ok1 || !ok2 {
+			return a[0], fmt.Errorf("round requires numeric arguments")
+		}
+		mult := math.Pow10(int(p))
+		return numValue(math.Round(f*mult) / mult), nil
+	}},
+	"floor": {1, numFunc(math.Floor)},
+	"ceil":  {1, numFunc(math.Ceil)},
+	"abs":   {1, numFunc(math.Abs)},
+	"int":   {1, numFunc(math.Trunc)},
+	"min": {2, func(a []exprValue) (exprValue, error) {
+		return numPair(a, math.Min)
+	}},
+	"max": {2, func(a []exprValue) (exprValue, error) {
+		return numPair(a, math.Max)
+	}},
+}
+
+func numFunc(f func(float64) float64) func(a []exprValue) (exprValue, error) {
+	return func(a []exprValue) (exprValue, error) {
+		x, ok := a[0].num()
+		if !ok {
+			return a[0], fmt.Errorf("expected a number, got '%s'", a[0].str())
+		}
+		return numValue(f(x)), nil
+	}
+}
+
+func numPair(a []exprValue, f func(float64, float64) float64) (exprValue, error) {
+	x, ok1 := a[0].num()
+	y, ok2 := a[1].num()
+	if !ok1 || !ok2 {
+		return a[0], fmt.Errorf("expected numbers, got '%s' and '%s'", a[0].str(), a[1].str())
+	}
+	return numValue(f(x, y)), nil
+}
+
+func (e exprCall) eval(lookup func(string) string) (exprValue, error) {
+	if e.name == "if" {
+		cond, err := e.args[0].eval(lookup)
+		if err != nil {
+			return cond, err
+		}
+		if cond.truthy() {
+			return e.args[1].eval(lookup)
+		}
+		return e.args[2].eval(lookup)
+	}
+	args := make([]exprValue, len(e.args))
+	for i, a := range e.args {
+		v, err := a.eval(lookup)
+		if err != nil {
+			return v, err
+		}
+		args[i] = v
+	}
+	return exprFuncs[e.name].f(args)
+}
+
+// exprParser is a precedence climbing parser over a simple expression language of
+// literals, identifiers, operators and function calls
+type exprParser struct {
+	src    string
+	pos    int
+	idents map[string]bool
+}
+
+var exprPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+// parseExpression parses src and returns the expression tree and the identifiers it references
+func parseExpression(src string) (exprNode, []string, error) {
+	p := &exprParser{src: src, idents: make(map[string]bool)}
+	n, err := p.parseBinary(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, nil, fmt.Errorf("unexpected '%s' at position %d", p.src[p.pos:], p.pos)
+	}
+	idents := make([]string, 0, len(p.idents))
+	for k := range p.idents {
+		idents = append(idents, k)
+	}
+	return n, idents, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peekOp() string {
+	p.skipSpace()
+	for _, l := range []int{2, 1} {
+		if p.pos+l <= len(p.src) {
+			if _, ok := exprPrecedence[p.src[p.pos:p.pos+l]]; ok {
+				return p.src[p.pos : p.pos+l]
+			}
+		}
+	}
+	return ""
+}
+
+func (p *exprParser) parseBinary(minPrec int) (exprNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peekOp()
+		prec, ok := exprPrecedence[op]
+		if !ok || prec < minPrec {
+			return l, nil
+		}
+		p.pos += len(op)
+		r, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: op, l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	p.skipSpace()
+	if p.pos < len(p.src) && (p.src[p.pos] == '-' || p.src[p.pos] == '!') {
+		op := string(p.src[p.pos])
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprUnary{op: op, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func isIdentChar(c byte, first bool) bool {
+	if c == '_' || c == '@' || unicode.IsLetter(rune(c)) {
+		return true
+	}
+	return !first && (c == '.' || unicode.IsDigit(rune(c)))
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		n, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] != ')' {
+			return nil, fmt.Errorf("missing ')' at position %d", p.pos)
+		}
+		p.pos++
+		return n, nil
+	case c == '"' || c == '\'':
+		end := strings.IndexByte(p.src[p.pos+1:], c)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string at position %d", p.pos)
+		}
+		s := p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return exprLiteral{v: strValue(s)}, nil
+	case unicode.IsDigit(rune(c)) || c == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		f, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", p.src[start:p.pos])
+		}
+		return exprLiteral{v: numValue(f)}, nil
+	case isIdentChar(c, true):
+		start := p.pos
+		for p.pos < len(p.src) && isIdentChar(p.src[p.pos], false) {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == '(' {
+			return p.parseCall(name)
+		}
+		p.idents[name] = true
+		return exprIdent{name: name}, nil
+	}
+	return nil, fmt.Errorf("unexpected '%c' at position %d", c, p.pos)
+}
+
+func (p *exprParser) parseCall(name string) (exprNode, error) {
+	fn, ok := exprFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function '%s'", name)
+	}
+	p.pos++ // (
+	args := make([]exprNode, 0)
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == ')' {
+		p.pos++
+	} else {
+		for {
+			a, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+			p.skipSpace()
+			if p.pos >= len(p.src) {
+				return nil, fmt.Errorf("missing ')' for function '%s'", name)
+			}
+			if p.src[p.pos] == ',' {
+				p.pos++
+				continue
+			}
+			if p.src[p.pos] == ')' {
+				p.pos++
+				break
+			}
+			return nil, fmt.Errorf("unexpected '%c' at position %d", p.src[p.pos], p.pos)
+		}
+	}
+	if fn.nargs >= 0 && len(args) != fn.nargs {
+		return nil, fmt.Errorf("function '%s' takes %d arguments, got %d", name, fn.nargs, len(args))
+	}
+	return exprCall{name: name, args: args}, nil
+}
+
+// setupExpression parses the token's replacement as an expression
+func (t *Token) setupExpression() error {
+	if strings.TrimSpace(t.Replacement) == "" {
+		return fmt.Errorf("expression cannot be empty")
+	}
+	n, deps, err := parseExpression(t.Replacement)
+	if err != nil {
+		return err
+	}
+	t.expr = n
+	t.exprDeps = deps
+	return nil
+}
+
+// Evaluate computes the value of an expression token.  Identifiers are looked up first in values, which
+// holds the values of other tokens already generated for this event, and then in the fields of the event.
+func (t Token) Evaluate(values map[string]string, fullevent map[string]string) (string, error) {
+	if t.expr == nil {
+		return "", fmt.Errorf("Expression not compiled for token '%s'", t.Name)
+	}
+	v, err := t.expr.eval(func(name string) string {
+		if v, ok := values[name]; ok {
+			return v
+		}
+		return fullevent[name]
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error evaluating expression '%s' for token '%s': %s", t.Replacement, t.Name, err)
+	}
+	if v.isNum && t.Precision > 0 {
+		return strconv.FormatFloat(v.f, 'f', t.Precision, 64), nil
+	}
+	return v.str(), nil
+}
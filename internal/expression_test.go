@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpressionEvaluate(t *testing.T) {
+	values := map[string]string{"bytes": "2048", "user": "Alice", "status": "503"}
+	fields := map[string]string{"host": "WEB-01", "status": "200"}
+	tests := []struct {
+		expr      string
+		precision int
+		expected  string
+	}{
+		{"bytes / 1024", 0, "2"},
+		{"bytes / 1000", 1, "2.0"},
+		{"1 + 2 * 3 - -1", 0, "8"},
+		{"(1 + 2) * 3 % 4", 0, "1"},
+		{"lower(host)", 0, "web-01"},
+		{"upper(user) + '-' + host", 0, "ALICE-WEB-01"},
+		{"md5(user)", 0, "64489c85dc2fe0787b85cd87214b3810"},
+		{"substr(host, 0, 3)", 0, "WEB"},
+		{"substr(status, 0, 1) + 'xx'", 0, "5xx"},
+		{"if(status >= 500, \"server_error\", \"ok\")", 0, "server_error"},
+		{"status == 503 && user != 'Bob'", 0, "1"},
+		{"!(bytes > 1) || missing == ''", 0, "1"},
+		{"round(bytes / 3, 2)", 0, "682.67"},
+		{"concat(user, '@', lower(host))", 0, "Alice@web-01"},
+		{"max(len(user), 10)", 0, "10"},
+	}
+	for _, tc := range tests {
+		tok := Token{Name: "test", Type: "expression", Replacement: tc.expr, Precision: tc.precision}
+		assert.NoError(t, tok.setupExpression(), tc.expr)
+		v, err := tok.Evaluate(values, fields)
+		assert.NoError(t, err, tc.expr)
+		assert.Equal(t, tc.expected, v, tc.expr)
+	}
+
+	tok := Token{Name: "test", Type: "expression", Replacement: "user * 2"}
+	assert.NoError(t, tok.setupExpression())
+	_, err := tok.Evaluate(values, fields)
+	assert.Error(t, err)
+
+	for _, bad := range []string{"", "md5(", "1 +", "nosuchfunc(1)", "lower(1, 2)", "'unterminated", "1 2"} {
+		tok := Token{Name: "test", Type: "expression", Replacement: bad}
+		assert.Error(t, tok.setupExpression(), bad)
+	}
+}
+
+func TestExpressionConfig(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join("..", "tests", "expression", "expression.yml"))
+	defer os.Unsetenv("GOGEN_FULLCONFIG")
+
+	c := NewConfig()
+	assert.Nil(t, c.FindSampleByName("circular"))
+	assert.Nil(t, c.FindSampleByName("badexpression"))
+
+	s := c.FindSampleByName("expression")
+	if !assert.NotNil(t, s) {
+		return
+	}
+	pos := make(map[string]int)
+	for i, idx := range s.TokenOrder {
+		pos[s.Tokens[idx].Name] = i
+	}
+	assert.Less(t, pos["bytes"], pos["bytes_kb"])
+	assert.Less(t, pos["user"], pos["user_hash"])
+}
@@ -1,8 +1,10 @@
 package internal
 
 import (
+	"context"
 	"math/rand"
 	"strconv"
+	"sync"
 	"time"
 
 	lua "github.com/yuin/gopher-lua"
@@ -23,12 +25,14 @@ type GenQueueItem struct {
 	S        *Sample
 	Count    int
 	Event    int
+	Line     map[string]string // Set instead of Event when replaying from a ReplayStream
 	Earliest time.Time
 	Latest   time.Time
 	Now      time.Time
 	OQ       chan *OutQueueItem
 	Rand     *rand.Rand
 	Cache    *CacheItem
+	Ctx      context.Context // Cancelled when the run is shutting down
 }
 
 // Generator will generate count events from earliest to latest time and put them
@@ -37,6 +41,35 @@ type Generator interface {
 	Gen(item *GenQueueItem) error
 }
 
+// GeneratorFactory creates a Generator.  Each generator worker calls it once for every sample using the generator.
+type GeneratorFactory func() Generator
+
+var (
+	generatorFactories     = make(map[string]GeneratorFactory)
+	generatorFactoriesLock sync.RWMutex
+)
+
+// RegisterGenerator makes a native Go generator available to samples by name.  It panics if the
+// name is already registered or factory is nil, so it is intended to be called from init.
+func RegisterGenerator(name string, factory GeneratorFactory) {
+	generatorFactoriesLock.Lock()
+	defer generatorFactoriesLock.Unlock()
+	if factory == nil {
+		panic("gogen: RegisterGenerator factory is nil for generator " + name)
+	}
+	if _, dup := generatorFactories[name]; dup {
+		panic("gogen: RegisterGenerator called twice for generator " + name)
+	}
+	generatorFactories[name] = factory
+}
+
+// FindGeneratorFactory returns the factory registered for name, or nil if there isn't one
+func FindGeneratorFactory(name string) GeneratorFactory {
+	generatorFactoriesLock.RLock()
+	defer generatorFactoriesLock.RUnlock()
+	return generatorFactories[name]
+}
+
 // GeneratorState maintains what a custom generator needs to store
 type GeneratorState struct {
 	LuaState *lua.LTable
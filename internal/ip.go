@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/netip"
+)
+
+// maxIPTries bounds how many addresses we'll draw looking for one which satisfies IPMode
+const maxIPTries = 100
+
+// WeightedCIDR is a CIDR block to generate IP addresses from, with a Weight relative to the other blocks
+type WeightedCIDR struct {
+	CIDR   string `json:"cidr" yaml:"cidr"`
+	Weight int    `json:"weight,omitempty" yaml:"weight,omitempty"`
+}
+
+var (
+	// reservedPrefixes are special purpose ranges from RFC 6890 and friends, excluded by IPMode "public"
+	reservedPrefixes = mustParsePrefixes(
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+		"192.0.0.0/24", "192.0.2.0/24", "192.88.99.0/24", "192.168.0.0/16", "198.18.0.0/15",
+		"198.51.100.0/24", "203.0.113.0/24", "224.0.0.0/4", "240.0.0.0/4",
+		"::1/128", "2001::/23", "2001:db8::/32", "2002::/16", "fc00::/7", "fe80::/10", "ff00::/8",
+	)
+	// privatePrefixes are RFC 1918 for IPv4 and unique local addresses from RFC 4193 for IPv6
+	privatePrefixes = mustParsePrefixes("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+	publicV4        = netip.MustParsePrefix("0.0.0.0/0")
+	publicV6        = netip.MustParsePrefix("2000::/3")
+)
+
+func mustParsePrefixes(cidrs ...string) []netip.Prefix {
+	ret := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		ret = append(ret, netip.MustParsePrefix(c))
+	}
+	return ret
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// conformingPrefixes splits p into the prefixes whose addresses all satisfy mode, which is empty if none do
+func conformingPrefixes(p netip.Prefix, mode string) []netip.Prefix {
+	prefixes, keepInside := reservedPrefixes, false
+	if mode == "private" {
+		prefixes, keepInside = privatePrefixes, true
+	}
+	overlaps := false
+	for _, q := range prefixes {
+		if q.Bits() <= p.Bits() && q.Contains(p.Addr()) {
+			if keepInside {
+				return []netip.Prefix{p}
+			}
+			return nil
+		}
+		if p.Overlaps(q) {
+			overlaps = true
+		}
+	}
+	if !overlaps {
+		if keepInside {
+			return nil
+		}
+		return []netip.Prefix{p}
+	}
+	lo, hi := splitPrefix(p)
+	return append(conformingPrefixes(lo, mode), conformingPrefixes(hi, mode)...)
+}
+
+// splitPrefix returns the two halves of p
+func splitPrefix(p netip.Prefix) (netip.Prefix, netip.Prefix) {
+	bits := p.Bits() + 1
+	b := p.Addr().AsSlice()
+	b[(bits-1)/8] |= 0x80 >> ((bits - 1) % 8)
+	hi, _ := netip.AddrFromSlice(b)
+	return netip.PrefixFrom(p.Addr(), bits), netip.PrefixFrom(hi, bits)
+}
+
+// setupIP parses CIDRs and IPMode into the prefixes and weights used by genIP.  With an IPMode, every CIDR
+// must contain addresses satisfying it.
+func (t *Token) setupIP() error {
+	v6 := t.Replacement == "ipv6"
+	if t.IPMode != "" && t.IPMode != "public" && t.IPMode != "private" {
+		return fmt.Errorf("ipMode must be 'public' or 'private', got '%s'", t.IPMode)
+	}
+	var cidrs []WeightedCIDR
+	if len(t.CIDRs) > 0 {
+		cidrs = t.CIDRs
+	} else if t.IPMode == "private" {
+		// Weight private ranges by size so addresses are uniform across private space
+		if v6 {
+			cidrs = []WeightedCIDR{{CIDR: "fc00::/7", Weight: 1}}
+		} else {
+			cidrs = []WeightedCIDR{{CIDR: "10.0.0.0/8", Weight: 1 << 24}, {CIDR: "172.16.0.0/12", Weight: 1 << 20}, {CIDR: "192.168.0.0/16", Weight: 1 << 16}}
+		}
+	} else if t.IPMode == "public" {
+		if v6 {
+			cidrs = []WeightedCIDR{{CIDR: publicV6.String(), Weight: 1}}
+		} else {
+			cidrs = []WeightedCIDR{{CIDR: publicV4.String(), Weight: 1}}
+		}
+	} else {
+		return nil
+	}
+	t.cidrPrefixes = make([]netip.Prefix, 0, len(cidrs))
+	t.cidrTotals = make([]int, 0, len(cidrs))
+	t.cidrRunningTotal = 0
+	t.cidrConforming = nil
+	t.cidrConformingTotals = nil
+	t.cidrConformingTotal = 0
+	for _, c := range cidrs {
+		p, err := netip.ParsePrefix(c.CIDR)
+		if err != nil {
+			return err
+		}
+		if p.Addr().Is6() != v6 {
+			return fmt.Errorf("CIDR '%s' does not match replacement '%s'", c.CIDR, t.Replacement)
+		}
+		if c.Weight < 0 {
+			return fmt.Errorf("weight for CIDR '%s' cannot be negative", c.CIDR)
+		}
+		var conforming []netip.Prefix
+		if t.IPMode != "" {
+			conforming = conformingPrefixes(p.Masked(), t.IPMode)
+			if len(conforming) == 0 {
+				return fmt.Errorf("CIDR '%s' has no %s addresses", c.CIDR, t.IPMode)
+			}
+		}
+		weight := c.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		// Weight the conforming parts by the chance the CIDR picks an address in them
+		for _, cp := range conforming {
+			t.cidrConformingTotal += float64(weight) * math.Ldexp(1, p.Bits()-cp.Bits())
+			t.cidrConforming = append(t.cidrConforming, cp)
+			t.cidrConformingTotals = append(t.cidrConformingTotals, t.cidrConformingTotal)
+		}
+		t.cidrRunningTotal += weight
+		t.cidrPrefixes = append(t.cidrPrefixes, p.Masked())
+		t.cidrTotals = append(t.cidrTotals, t.cidrRunningTotal)
+	}
+	return nil
+}
+
+// genIP picks a CIDR block by weight and returns a random address within it, satisfying IPMode.  If no
+// address drawn satisfies IPMode, one is drawn from the parts of the CIDR blocks which do.
+func (t Token) genIP(randgen *rand.Rand) string {
+	for i := 0; i < maxIPTries; i++ {
+		r := randgen.Intn(t.cidrRunningTotal)
+		p := t.cidrPrefixes[len(t.cidrPrefixes)-1]
+		for j, total := range t.cidrTotals {
+			if r < total {
+				p = t.cidrPrefixes[j]
+				break
+			}
+		}
+		addr := randomAddrInPrefix(randgen, p)
+		switch t.IPMode {
+		case "public":
+			if !prefixesContain(reservedPrefixes, addr) {
+				return addr.String()
+			}
+		case "private":
+			if prefixesContain(privatePrefixes, addr) {
+				return addr.String()
+			}
+		default:
+			return addr.String()
+		}
+	}
+	return t.genConformingIP(randgen).String()
+}
+
+// genConformingIP returns a random address from the parts of the CIDR blocks which satisfy IPMode, as likely
+// as drawing it from the blocks by weight
+func (t Token) genConformingIP(randgen *rand.Rand) netip.Addr {
+	r := randgen.Float64() * t.cidrConformingTotal
+	p := t.cidrConforming[len(t.cidrConforming)-1]
+	for j, total := range t.cidrConformingTotals {
+		if r < total {
+			p = t.cidrConforming[j]
+			break
+		}
+	}
+	return randomAddrInPrefix(randgen, p)
+}
+
+// randomAddrInPrefix keeps the network bits of p and randomizes the host bits
+func randomAddrInPrefix(randgen *rand.Rand, p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	bits := p.Bits()
+	for i := range b {
+		hostBits := (i+1)*8 - bits
+		if hostBits <= 0 {
+			continue
+		}
+		if hostBits > 8 {
+			hostBits = 8
+		}
+		mask := byte(0xff >> (8 - hostBits))
+		b[i] = b[i]&^mask | byte(randgen.Intn(256))&mask
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
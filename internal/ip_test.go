@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"math/rand"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func genIPs(t *testing.T, token Token, n int) []netip.Addr {
+	assert.NoError(t, token.setupIP())
+	randgen := rand.New(rand.NewSource(0))
+	now := time.Now()
+	ret := make([]netip.Addr, 0, n)
+	for i := 0; i < n; i++ {
+		r, _, err := token.GenReplacement(-1, now, now, now, randgen, map[string]string{})
+		assert.NoError(t, err)
+		addr, err := netip.ParseAddr(r)
+		assert.NoError(t, err)
+		ret = append(ret, addr)
+	}
+	return ret
+}
+
+func TestIPCIDRs(t *testing.T) {
+	token := Token{Name: "ip", Type: "random", Replacement: "ipv4", CIDRs: []WeightedCIDR{
+		{CIDR: "10.1.0.0/16", Weight: 9},
+		{CIDR: "203.0.113.0/28", Weight: 1},
+	}}
+	p1 := netip.MustParsePrefix("10.1.0.0/16")
+	p2 := netip.MustParsePrefix("203.0.113.0/28")
+	counts := make([]int, 2)
+	for _, addr := range genIPs(t, token, 1000) {
+		if p1.Contains(addr) {
+			counts[0]++
+		} else if p2.Contains(addr) {
+			counts[1]++
+		} else {
+			t.Fatalf("Address %s not in configured CIDRs", addr)
+		}
+	}
+	assert.InDelta(t, 900, counts[0], 50)
+
+	token = Token{Name: "ip", Type: "random", Replacement: "ipv6", CIDRs: []WeightedCIDR{{CIDR: "2001:db8:1::/48"}}}
+	p := netip.MustParsePrefix("2001:db8:1::/48")
+	for _, addr := range genIPs(t, token, 100) {
+		assert.True(t, p.Contains(addr), addr.String())
+	}
+}
+
+func TestIPMode(t *testing.T) {
+	for _, replacement := range []string{"ipv4", "ipv6"} {
+		token := Token{Name: "ip", Type: "random", Replacement: replacement, IPMode: "public"}
+		for _, addr := range genIPs(t, token, 1000) {
+			assert.False(t, prefixesContain(reservedPrefixes, addr), addr.String())
+		}
+		token = Token{Name: "ip", Type: "random", Replacement: replacement, IPMode: "private"}
+		for _, addr := range genIPs(t, token, 1000) {
+			assert.True(t, prefixesContain(privatePrefixes, addr), addr.String())
+		}
+	}
+}
+
+func TestIPModeCIDRs(t *testing.T) {
+	// Few addresses in these CIDRs satisfy the mode, so some are drawn from the parts which do
+	token := Token{Name: "ip", Type: "random", Replacement: "ipv4", IPMode: "private", CIDRs: []WeightedCIDR{{CIDR: "0.0.0.0/0"}}}
+	for _, addr := range genIPs(t, token, 1000) {
+		assert.True(t, prefixesContain(privatePrefixes, addr), addr.String())
+	}
+	token = Token{Name: "ip", Type: "random", Replacement: "ipv6", IPMode: "public", CIDRs: []WeightedCIDR{
+		{CIDR: "::/120"},
+		{CIDR: "fe00::/7"},
+	}}
+	for _, addr := range genIPs(t, token, 1000) {
+		assert.False(t, prefixesContain(reservedPrefixes, addr), addr.String())
+	}
+}
+
+func TestIPModeConformingWeights(t *testing.T) {
+	// The parts of a block which satisfy the mode are as likely as drawing from the blocks by weight
+	token := Token{Name: "ip", Type: "random", Replacement: "ipv4", IPMode: "private", CIDRs: []WeightedCIDR{
+		{CIDR: "0.0.0.0/0", Weight: 1},
+		{CIDR: "192.168.1.0/24", Weight: 1},
+	}}
+	assert.NoError(t, token.setupIP())
+	randgen := rand.New(rand.NewSource(0))
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		addr := token.genConformingIP(randgen)
+		for _, p := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.1.0/24", "192.168.0.0/16"} {
+			if netip.MustParsePrefix(p).Contains(addr) {
+				counts[p]++
+				break
+			}
+		}
+	}
+	// 0.0.0.0/0 has 2^24+2^20+2^16 private addresses out of 2^32, so nearly every draw is from 192.168.1.0/24
+	assert.Greater(t, counts["192.168.1.0/24"], 9900)
+	assert.Greater(t, counts["10.0.0.0/8"], counts["172.16.0.0/12"])
+}
+
+func TestIPSetupErrors(t *testing.T) {
+	bad := []Token{
+		{Replacement: "ipv4", IPMode: "bogus"},
+		{Replacement: "ipv4", CIDRs: []WeightedCIDR{{CIDR: "10.0.0.0/33"}}},
+		{Replacement: "ipv4", CIDRs: []WeightedCIDR{{CIDR: "fc00::/7"}}},
+		{Replacement: "ipv6", CIDRs: []WeightedCIDR{{CIDR: "10.0.0.0/8"}}},
+		{Replacement: "ipv4", CIDRs: []WeightedCIDR{{CIDR: "10.0.0.0/8", Weight: -1}}},
+		{Replacement: "ipv4", IPMode: "public", CIDRs: []WeightedCIDR{{CIDR: "10.1.0.0/16"}}},
+		{Replacement: "ipv4", IPMode: "private", CIDRs: []WeightedCIDR{{CIDR: "8.8.8.0/24"}}},
+		{Replacement: "ipv6", IPMode: "public", CIDRs: []WeightedCIDR{{CIDR: "fe80::/64"}}},
+	}
+	for _, token := range bad {
+		assert.Error(t, token.setupIP(), "%#v", token)
+	}
+}
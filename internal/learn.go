@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	log "github.com/coccyx/gogen/logger"
+)
+
+// minutesPerWeek is the period of every key multipliers are learned by
+const minutesPerWeek = 7 * 24 * 60
+
+// LearnedRates counts the events in every minute of a log with events, and how many minutes there are between
+// the first and last timestamps, so rater curves matching the log's volume can be learned from it
+type LearnedRates struct {
+	start   time.Time
+	span    int64
+	minutes map[int64]int
+}
+
+// LearnRates reads the log at path, which may be gzip compressed, and counts the events in it by the
+// timestamp token t, parsing timestamps the same way as replay.  Lines without a timestamp, like the
+// continuation lines of multiline events, aren't counted.
+func LearnRates(path string, t Token) (*LearnedRates, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := decompress(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading gzip file '%s': %s", path, err)
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxReplayLineSize)
+	minutes := make(map[int64]int)
+	var first, last time.Time
+	for scanner.Scan() {
+		line := scanner.Text()
+		offsets, err := t.GetReplacementOffsets(line)
+		if err != nil || len(offsets) == 0 {
+			continue
+		}
+		ts, err := t.ParseTimestamp(line[offsets[0][0]:offsets[0][1]])
+		if err != nil {
+			log.Debugf("Error parsing timestamp in '%s': %s", path, err)
+			continue
+		}
+		ts = ts.Truncate(time.Minute)
+		if first.IsZero() || ts.Before(first) {
+			first = ts
+		}
+		if last.IsZero() || ts.After(last) {
+			last = ts
+		}
+		minutes[ts.Unix()]++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading '%s': %s", path, err)
+	}
+	if len(minutes) == 0 {
+		return nil, fmt.Errorf("no timestamps for token '%s' found in '%s'", t.Name, path)
+	}
+	return &LearnedRates{start: first, span: int64(last.Sub(first)/time.Minute) + 1, minutes: minutes}, nil
+}
+
+// observed counts the minutes of the log with every key.  Keys repeat every week, so whole weeks are counted
+// once and multiplied, and a log spanning years with a few outlying timestamps is still quick to learn from.
+func (l *LearnedRates) observed(key func(t time.Time) int) map[int]int64 {
+	n := make(map[int]int64)
+	weeks := l.span / minutesPerWeek
+	if weeks > 0 {
+		for i := int64(0); i < minutesPerWeek; i++ {
+			n[key(l.start.Add(time.Duration(i)*time.Minute))] += weeks
+		}
+	}
+	for i := weeks * minutesPerWeek; i < l.span; i++ {
+		n[key(l.start.Add(time.Duration(i)*time.Minute))]++
+	}
+	return n
+}
+
+// multipliers averages the events per minute for every key, over the minutes of the log with that key, and
+// divides the averages by their mean.  Keys which never occur in the log are left out.
+func (l *LearnedRates) multipliers(key func(t time.Time) int) map[int]float64 {
+	n := l.observed(key)
+	sums := make(map[int]float64, len(n))
+	for k := range n {
+		sums[k] = 0
+	}
+	loc := l.start.Location()
+	for m, count := range l.minutes {
+		sums[key(time.Unix(m, 0).In(loc))] += float64(count)
+	}
+	mean := 0.0
+	for k := range sums {
+		sums[k] /= float64(n[k])
+		mean += sums[k]
+	}
+	mean /= float64(len(sums))
+	ret := make(map[int]float64, len(sums))
+	for k, avg := range sums {
+		if mean == 0 {
+			ret[k] = 1.0
+		} else {
+			ret[k] = math.Round(avg/mean*1000) / 1000
+		}
+	}
+	return ret
+}
+
+// ConfigRater returns a config rater named name with HourOfDay, DayOfWeek and MinuteOfHour multipliers
+// learned from the log, each normalized to a mean of 1
+func (l *LearnedRates) ConfigRater(name string) *RaterConfig {
+	return &RaterConfig{
+		Name: name,
+		Type: "config",
+		Options: map[string]interface{}{
+			"HourOfDay":    l.multipliers(func(t time.Time) int { return t.Hour() }),
+			"DayOfWeek":    l.multipliers(func(t time.Time) int { return int(t.Weekday()) }),
+			"MinuteOfHour": l.multipliers(func(t time.Time) int { return t.Minute() }),
+		},
+	}
+}
+
+// TimeSeriesRater writes an hour of week time series learned from the log to w, normalized to a mean of 1,
+// and returns a step timeseries rater named name reading it from file
+func (l *LearnedRates) TimeSeriesRater(name string, file string, w io.Writer) (*RaterConfig, error) {
+	how := l.multipliers(func(t time.Time) int { return int(t.Weekday())*24 + t.Hour() })
+	if _, err := fmt.Fprintln(w, "hourOfWeek,multiplier"); err != nil {
+		return nil, err
+	}
+	for h := 0; h < hoursPerWeek; h++ {
+		if v, ok := how[h]; ok {
+			if _, err := fmt.Fprintf(w, "%d,%g\n", h, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &RaterConfig{
+		Name: name,
+		Type: "timeseries",
+		Options: map[string]interface{}{
+			"file":          file,
+			"interpolation": "step",
+		},
+	}, nil
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeLearnLog writes a log with 1 event a minute from midnight to noon UTC on Saturday, October 20, 2001,
+// and 3 a minute from noon to midnight, with a continuation line after every event
+func writeLearnLog(t *testing.T, gz bool) string {
+	var b bytes.Buffer
+	start := time.Date(2001, 10, 20, 0, 0, 0, 0, time.UTC)
+	for m := 0; m < 24*60; m++ {
+		ts := start.Add(time.Duration(m) * time.Minute)
+		n := 1
+		if ts.Hour() >= 12 {
+			n = 3
+		}
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "%s GET /index.html\n  continued\n", ts.Add(time.Duration(i)*time.Second).Format(time.RFC3339))
+		}
+	}
+	path := filepath.Join(t.TempDir(), "learn.log")
+	contents := b.Bytes()
+	if gz {
+		var zb bytes.Buffer
+		w := gzip.NewWriter(&zb)
+		w.Write(contents)
+		w.Close()
+		contents = zb.Bytes()
+	}
+	assert.NoError(t, os.WriteFile(path, contents, 0644))
+	return path
+}
+
+func learnToken() Token {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join("..", "tests", "learn", "learn.yml"))
+	c := NewConfig()
+	return c.FindSampleByName("learn").Tokens[0]
+}
+
+func TestLearnConfigRater(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		l, err := LearnRates(writeLearnLog(t, gz), learnToken())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(24*60), l.span)
+
+		r := l.ConfigRater("learned")
+		assert.Equal(t, "learned", r.Name)
+		assert.Equal(t, "config", r.Type)
+		hod := r.Options["HourOfDay"].(map[int]float64)
+		assert.Len(t, hod, 24)
+		assert.Equal(t, 0.5, hod[0])
+		assert.Equal(t, 1.5, hod[23])
+		assert.Equal(t, map[int]float64{6: 1.0}, r.Options["DayOfWeek"])
+		moh := r.Options["MinuteOfHour"].(map[int]float64)
+		assert.Len(t, moh, 60)
+		assert.Equal(t, 1.0, moh[30])
+	}
+}
+
+func TestLearnTimeSeriesRater(t *testing.T) {
+	l, err := LearnRates(writeLearnLog(t, false), learnToken())
+	assert.NoError(t, err)
+	var b bytes.Buffer
+	r, err := l.TimeSeriesRater("learned", "learned.csv", &b)
+	assert.NoError(t, err)
+	assert.Equal(t, "timeseries", r.Type)
+	assert.Equal(t, "learned.csv", r.Options["file"])
+	assert.Equal(t, "step", r.Options["interpolation"])
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Len(t, lines, 25)
+	assert.Equal(t, "hourOfWeek,multiplier", lines[0])
+	assert.Equal(t, "144,0.5", lines[1])
+	assert.Equal(t, "167,1.5", lines[24])
+
+	path := filepath.Join(t.TempDir(), "learned.csv")
+	assert.NoError(t, os.WriteFile(path, b.Bytes(), 0644))
+	ts, err := LoadTimeSeries(path, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, ts.Rate(time.Date(2001, 10, 27, 13, 30, 0, 0, time.Local)))
+}
+
+func TestLearnRatesOutliers(t *testing.T) {
+	// Outlying timestamps decades from the rest of the log don't need a count for every minute between them
+	path := writeLearnLog(t, false)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	fmt.Fprintln(f, "1971-01-01T00:00:00Z GET /index.html")
+	fmt.Fprintln(f, "2099-12-31T00:00:00Z GET /index.html")
+	f.Close()
+
+	l, err := LearnRates(path, learnToken())
+	assert.NoError(t, err)
+	span := int64(time.Date(2099, 12, 31, 0, 0, 0, 0, time.UTC).Sub(time.Date(1971, 1, 1, 0, 0, 0, 0, time.UTC))/time.Minute) + 1
+	assert.Equal(t, span, l.span)
+	total := int64(0)
+	for _, n := range l.observed(func(t time.Time) int { return t.Hour() }) {
+		total += n
+	}
+	assert.Equal(t, span, total)
+
+	r := l.ConfigRater("learned")
+	hod := r.Options["HourOfDay"].(map[int]float64)
+	assert.Len(t, hod, 24)
+	assert.Greater(t, hod[23], hod[0])
+	assert.Len(t, r.Options["DayOfWeek"], 7)
+}
+
+func TestLearnRatesNoTimestamps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.log")
+	assert.NoError(t, os.WriteFile(path, []byte("no timestamps here\n"), 0644))
+	_, err := LearnRates(path, learnToken())
+	assert.Error(t, err)
+	_, err = LearnRates(filepath.Join(t.TempDir(), "notfound.log"), learnToken())
+	assert.Error(t, err)
+}
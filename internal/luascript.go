@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// LuaScript is a Lua script compiled once and run in a pool of Lua states.  Every run gets a fresh global
+// environment, so globals set by one run are never seen by another.  The state table is shared across every
+// run, so a run which reads state holds a lock until it finishes, and runs which don't are concurrent.
+type LuaScript struct {
+	proto  *lua.FunctionProto
+	states *sync.Pool
+	state  *lua.LTable
+	mutex  *sync.Mutex
+}
+
+// luaRun is one run of a LuaScript, recording whether it has taken the lock for the state table
+type luaRun struct {
+	ls     *LuaScript
+	locked bool
+}
+
+// NewLuaScript compiles script.  state is the table scripts see as the global state, which may be nil.  setup
+// is called on every new Lua state added to the pool to register globals.
+func NewLuaScript(name string, script string, state *lua.LTable, setup func(L *lua.LState)) (*LuaScript, error) {
+	chunk, err := parse.Parse(strings.NewReader(script), name)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, err
+	}
+	return &LuaScript{
+		proto: proto,
+		states: &sync.Pool{
+			New: func() interface{} {
+				L := lua.NewState()
+				if setup != nil {
+					setup(L)
+				}
+				return L
+			},
+		},
+		state: state,
+		mutex: &sync.Mutex{},
+	}, nil
+}
+
+// Run executes the script and returns its return value.  globals is called before running to set any per call globals.
+func (ls *LuaScript) Run(globals func(L *lua.LState)) (lua.LValue, error) {
+	L := ls.states.Get().(*lua.LState)
+	defer ls.states.Put(L)
+	if globals != nil {
+		globals(L)
+	}
+	run := &luaRun{ls: ls}
+	defer run.unlock()
+
+	// Globals set by the script go in env, and everything else is read from the Lua state's globals
+	env := L.NewTable()
+	mt := L.NewTable()
+	mt.RawSetString("__index", L.NewFunction(run.index))
+	L.SetMetatable(env, mt)
+	env.RawSetString("_G", env)
+	fn := L.NewFunctionFromProto(ls.proto)
+	fn.Env = env
+
+	L.Push(fn)
+	if err := L.PCall(0, 1, nil); err != nil {
+		L.SetTop(0)
+		return lua.LNil, err
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	return ret, nil
+}
+
+// index looks up globals the script hasn't set, taking the lock the first time state is read
+func (r *luaRun) index(L *lua.LState) int {
+	key := L.Get(2)
+	if r.ls.state != nil && key == lua.LString("state") {
+		if !r.locked {
+			r.ls.mutex.Lock()
+			r.locked = true
+		}
+		L.Push(r.ls.state)
+		return 1
+	}
+	L.Push(L.G.Global.RawGet(key))
+	return 1
+}
+
+func (r *luaRun) unlock() {
+	if r.locked {
+		r.ls.mutex.Unlock()
+		r.locked = false
+	}
+}
+
+// SetLuaRand makes math.random and math.randomseed in L draw from r instead of the process wide source, so
+// scripts generate the same values for the same seed.  Does nothing if r is nil.
+func SetLuaRand(L *lua.LState, r *rand.Rand) {
+	math, ok := L.GetGlobal("math").(*lua.LTable)
+	if !ok || r == nil {
+		return
+	}
+	L.SetField(math, "random", L.NewFunction(func(L *lua.LState) int {
+		switch L.GetTop() {
+		case 0:
+			L.Push(lua.LNumber(r.Float64()))
+		case 1:
+			n := L.CheckInt(1)
+			L.Push(lua.LNumber(r.Intn(n) + 1))
+		default:
+			min := L.CheckInt(1)
+			max := L.CheckInt(2) + 1
+			L.Push(lua.LNumber(r.Intn(max-min) + min))
+		}
+		return 1
+	}))
+	L.SetField(math, "randomseed", L.NewFunction(func(L *lua.LState) int {
+		r.Seed(L.CheckInt64(1))
+		return 0
+	}))
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+)
+
+func TestLuaScript(t *testing.T) {
+	_, err := NewLuaScript("bad", "return (", nil, nil)
+	assert.Error(t, err)
+
+	ls, err := NewLuaScript("stateless", "return options * 2", nil, func(L *lua.LState) {
+		L.SetGlobal("options", lua.LNumber(21))
+	})
+	assert.NoError(t, err)
+	ret, err := ls.Run(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, lua.LNumber(42), ret)
+
+	ls, err = NewLuaScript("error", "error('boom')", nil, nil)
+	assert.NoError(t, err)
+	_, err = ls.Run(nil)
+	assert.Error(t, err)
+
+	// Scripts referencing state share it across every pooled Lua state without losing updates
+	state := new(lua.LTable)
+	state.RawSetString("id", lua.LNumber(0))
+	ls, err = NewLuaScript("stateful", `state["id"] = state["id"] + 1
+return state["id"]`, state, nil)
+	assert.NoError(t, err)
+	runConcurrently(t, ls)
+	assert.Equal(t, lua.LNumber(800), state.RawGetString("id"))
+
+	// Reaching state without naming it still takes the lock
+	state.RawSetString("id", lua.LNumber(0))
+	ls, err = NewLuaScript("hidden", `local s = _G["sta".."te"]
+s["id"] = s["id"] + 1`, state, nil)
+	assert.NoError(t, err)
+	runConcurrently(t, ls)
+	assert.Equal(t, lua.LNumber(800), state.RawGetString("id"))
+}
+
+func TestLuaScriptGlobals(t *testing.T) {
+	// Globals set by one run aren't seen by the next, even in the same pooled Lua state
+	ls, err := NewLuaScript("globals", `local ret = (seen or "unset") .. options
+seen = "set"
+options = 0
+return ret`, nil, func(L *lua.LState) {
+		L.SetGlobal("options", lua.LNumber(1))
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		ret, err := ls.Run(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, lua.LString("unset1"), ret)
+	}
+}
+
+// runConcurrently runs ls 100 times in each of 8 goroutines
+func runConcurrently(t *testing.T, ls *LuaScript) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := ls.Run(nil)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+const defaultMarkovOrder = 2
+const defaultMarkovLength = 30
+
+// markovChain is a word level n-gram model.  Each state is the previous Order words joined by a separator,
+// and maps to every word which followed it in the training lines, so common words are picked more often.
+// An empty next word marks the end of a line.
+type markovChain struct {
+	order       int
+	transitions map[string][]string
+}
+
+// markovSep joins words into a state and can't appear inside a word
+const markovSep = "\x00"
+
+// setupMarkov trains the token's chain from its Choice lines, resolved from a sample by resolveTokenSamples
+func (t *Token) setupMarkov() error {
+	if len(t.Choice) == 0 {
+		return fmt.Errorf("no lines to train from")
+	}
+	if t.Order < 0 {
+		return fmt.Errorf("order cannot be negative")
+	}
+	if t.Length < 0 {
+		return fmt.Errorf("length cannot be negative")
+	}
+	order := t.Order
+	if order == 0 {
+		order = defaultMarkovOrder
+	}
+	m := &markovChain{order: order, transitions: make(map[string][]string)}
+	for _, line := range t.Choice {
+		m.train(strings.Fields(line))
+	}
+	if len(m.transitions[m.start()]) == 0 {
+		return fmt.Errorf("no words to train from")
+	}
+	t.markov = m
+	return nil
+}
+
+func (m *markovChain) start() string {
+	return strings.Repeat(markovSep, m.order-1)
+}
+
+func (m *markovChain) train(words []string) {
+	if len(words) == 0 {
+		return
+	}
+	state := make([]string, m.order)
+	for _, w := range append(words, "") {
+		key := strings.Join(state, markovSep)
+		m.transitions[key] = append(m.transitions[key], w)
+		state = append(state[1:], w)
+	}
+}
+
+// genMarkov walks the chain from the start of a line until it reaches the end of a line or Length words
+func (t Token) genMarkov(randgen *rand.Rand) string {
+	m := t.markov
+	length := t.Length
+	if length == 0 {
+		length = defaultMarkovLength
+	}
+	state := make([]string, m.order)
+	words := make([]string, 0, length)
+	for len(words) < length {
+		next := m.transitions[strings.Join(state, markovSep)]
+		if len(next) == 0 {
+			break
+		}
+		w := next[randgen.Intn(len(next))]
+		if w == "" {
+			break
+		}
+		words = append(words, w)
+		state = append(state[1:], w)
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkov(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join("..", "tests", "markov", "markov.yml"))
+	defer os.Unsetenv("GOGEN_FULLCONFIG")
+
+	c := NewConfig()
+	assert.Nil(t, c.FindSampleByName("emptymarkov"))
+	s := c.FindSampleByName("markov")
+	if !assert.NotNil(t, s) {
+		return
+	}
+	tok := s.Tokens[0]
+	training := make(map[string]bool)
+	vocabulary := make(map[string]bool)
+	for _, line := range tok.Choice {
+		training[line] = true
+		for _, w := range strings.Fields(line) {
+			vocabulary[w] = true
+		}
+	}
+	assert.Len(t, training, 11)
+
+	randgen := rand.New(rand.NewSource(0))
+	n := time.Now()
+	novel := 0
+	for i := 0; i < 200; i++ {
+		v, _, err := tok.GenReplacement(-1, n, n, n, randgen, nil)
+		assert.NoError(t, err)
+		words := strings.Fields(v)
+		assert.True(t, len(words) > 0 && len(words) <= 20, v)
+		for _, w := range words {
+			assert.True(t, vocabulary[w], "word %s not in training lines", w)
+		}
+		if !training[v] {
+			novel++
+		}
+	}
+	assert.Greater(t, novel, 50)
+
+	// Every generated bigram appears in the training lines with order 2
+	tok = Token{Name: "order2", Choice: tok.Choice}
+	assert.NoError(t, tok.setupMarkov())
+	bigrams := make(map[string]bool)
+	for line := range training {
+		words := strings.Fields(line)
+		for i := 1; i < len(words); i++ {
+			bigrams[words[i-1]+" "+words[i]] = true
+		}
+	}
+	for i := 0; i < 100; i++ {
+		words := strings.Fields(tok.genMarkov(randgen))
+		for j := 1; j < len(words); j++ {
+			assert.True(t, bigrams[words[j-1]+" "+words[j]])
+		}
+	}
+
+	assert.Error(t, (&Token{Choice: []string{" "}}).setupMarkov())
+	assert.Error(t, (&Token{Choice: []string{"a b"}, Order: -1}).setupMarkov())
+}
@@ -1,8 +1,10 @@
 package internal
 
 import (
+	"context"
 	"io"
 	"math/rand"
+	"sync"
 )
 
 // OutQueueItem represents one batch of events to output
@@ -13,6 +15,7 @@ type OutQueueItem struct {
 	IO     *OutputIO
 	OS     chan *OutputStats
 	Cache  *CacheItem
+	Ctx    context.Context // Cancelled when the run is shutting down, so slow outputs can give up early
 }
 
 // OutputStats are sent by each outputter to the ReadOutThread for accounting
@@ -40,3 +43,32 @@ type Outputter interface {
 	Send(item *OutQueueItem) error
 	Close() error
 }
+
+// OutputterFactory creates an Outputter.  Each output worker calls it once, when it receives its first item.
+type OutputterFactory func() Outputter
+
+var (
+	outputterFactories     = make(map[string]OutputterFactory)
+	outputterFactoriesLock sync.RWMutex
+)
+
+// RegisterOutputter makes a native Go outputter available to samples by name.  It panics if the
+// name is already registered or factory is nil, so it is intended to be called from init.
+func RegisterOutputter(name string, factory OutputterFactory) {
+	outputterFactoriesLock.Lock()
+	defer outputterFactoriesLock.Unlock()
+	if factory == nil {
+		panic("gogen: RegisterOutputter factory is nil for outputter " + name)
+	}
+	if _, dup := outputterFactories[name]; dup {
+		panic("gogen: RegisterOutputter called twice for outputter " + name)
+	}
+	outputterFactories[name] = factory
+}
+
+// FindOutputterFactory returns the factory registered for name, or nil if there isn't one
+func FindOutputterFactory(name string) OutputterFactory {
+	outputterFactoriesLock.RLock()
+	defer outputterFactoriesLock.RUnlock()
+	return outputterFactories[name]
+}
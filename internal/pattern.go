@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+// defaultPatternRepeat is the most times *, +, {n,} and {n,m} repeat in a pattern unless Length is set
+const defaultPatternRepeat = 10
+
+// printableASCII is the range characters are drawn from for . and classes like \W or [^a] which cover most of unicode
+var printableASCII = []rune{0x20, 0x7e}
+
+// setupPattern parses the token's pattern for generating strings which match it
+func (t *Token) setupPattern() error {
+	if t.Pattern == "" {
+		return fmt.Errorf("pattern cannot be empty")
+	}
+	if t.Length < 0 {
+		return fmt.Errorf("length cannot be negative")
+	}
+	re, err := syntax.Parse(t.Pattern, syntax.Perl)
+	if err != nil {
+		return err
+	}
+	if err := checkPattern(re); err != nil {
+		return err
+	}
+	t.pattern = re
+	return nil
+}
+
+// checkPattern rejects patterns which can never match
+func checkPattern(re *syntax.Regexp) error {
+	if re.Op == syntax.OpNoMatch || (re.Op == syntax.OpCharClass && len(re.Rune) == 0) {
+		return fmt.Errorf("pattern can never match")
+	}
+	for _, sub := range re.Sub {
+		if err := checkPattern(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// genPattern generates a random string matching the token's pattern
+func (t Token) genPattern(randgen *rand.Rand) string {
+	limit := t.Length
+	if limit == 0 {
+		limit = defaultPatternRepeat
+	}
+	var b strings.Builder
+	writePattern(&b, t.pattern, randgen, limit)
+	return b.String()
+}
+
+func writePattern(b *strings.Builder, re *syntax.Regexp, randgen *rand.Rand, limit int) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if re.Flags&syntax.FoldCase != 0 && randgen.Intn(2) == 0 {
+				r = unicode.SimpleFold(r)
+			}
+			b.WriteRune(r)
+		}
+	case syntax.OpCharClass:
+		b.WriteRune(randRune(re.Rune, randgen))
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		b.WriteRune(randRune(printableASCII, randgen))
+	case syntax.OpCapture:
+		writePattern(b, re.Sub[0], randgen, limit)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			writePattern(b, sub, randgen, limit)
+		}
+	case syntax.OpAlternate:
+		writePattern(b, re.Sub[randgen.Intn(len(re.Sub))], randgen, limit)
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		min, max := 0, limit
+		switch re.Op {
+		case syntax.OpPlus:
+			min = 1
+		case syntax.OpQuest:
+			max = 1
+		case syntax.OpRepeat:
+			// Explicit repeats are capped by limit too, but always repeat at least min times so they match
+			min, max = re.Min, re.Max
+			if max < 0 {
+				max = min + limit
+			} else if max > limit {
+				max = limit
+			}
+		}
+		if max < min {
+			max = min
+		}
+		n := min + randgen.Intn(max-min+1)
+		for i := 0; i < n; i++ {
+			writePattern(b, re.Sub[0], randgen, limit)
+		}
+	}
+	// Anchors, word boundaries and empty matches generate nothing
+}
+
+// randRune picks a rune uniformly from a list of inclusive ranges.  Printable ASCII in the ranges
+// is preferred, so negated and unicode classes don't generate unprintable characters.
+func randRune(ranges []rune, randgen *rand.Rand) rune {
+	ascii := intersectRanges(ranges, printableASCII)
+	if len(ascii) > 0 {
+		ranges = ascii
+	}
+	total := 0
+	for i := 0; i < len(ranges); i += 2 {
+		total += int(ranges[i+1]-ranges[i]) + 1
+	}
+	n := randgen.Intn(total)
+	for i := 0; i < len(ranges); i += 2 {
+		size := int(ranges[i+1]-ranges[i]) + 1
+		if n < size {
+			return ranges[i] + rune(n)
+		}
+		n -= size
+	}
+	return ranges[0]
+}
+
+// intersectRanges returns the parts of the inclusive ranges which fall within the single range bounds
+func intersectRanges(ranges []rune, bounds []rune) []rune {
+	ret := make([]rune, 0, len(ranges))
+	for i := 0; i < len(ranges); i += 2 {
+		lo, hi := ranges[i], ranges[i+1]
+		if lo < bounds[0] {
+			lo = bounds[0]
+		}
+		if hi > bounds[1] {
+			hi = bounds[1]
+		}
+		if lo <= hi {
+			ret = append(ret, lo, hi)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"math/rand"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPattern(t *testing.T) {
+	randgen := rand.New(rand.NewSource(0))
+	n := time.Now()
+	patterns := []string{
+		`[A-Z]{3}-\d{4}`,
+		`(GET|POST) /api/v[12]/\w{4,8}`,
+		`^[a-f0-9]{8}(-[a-f0-9]{4}){3}-[a-f0-9]{12}$`,
+		`(?i)ticket-[^\s]+\.?x*`,
+		`\W\S. end`,
+	}
+	for _, p := range patterns {
+		tok := Token{Type: "random", Replacement: "pattern", Pattern: p}
+		assert.NoError(t, tok.setupPattern(), p)
+		re := regexp.MustCompile(`^(?:` + p + `)$`)
+		for i := 0; i < 100; i++ {
+			v, _, err := tok.GenReplacement(-1, n, n, n, randgen, nil)
+			assert.NoError(t, err)
+			assert.Regexp(t, re, v)
+			for _, r := range v {
+				assert.True(t, r >= 0x20 && r <= 0x7e, "unprintable %q in %q", r, v)
+			}
+		}
+	}
+
+	// Unbounded repetition is capped by length
+	tok := Token{Type: "random", Replacement: "pattern", Pattern: `a+b*`, Length: 3}
+	assert.NoError(t, tok.setupPattern())
+	for i := 0; i < 100; i++ {
+		assert.LessOrEqual(t, len(tok.genPattern(randgen)), 6)
+	}
+
+	// So are explicit repeats, beyond their minimum
+	tok = Token{Type: "random", Replacement: "pattern", Pattern: `a{1,1000}`, Length: 5}
+	assert.NoError(t, tok.setupPattern())
+	for i := 0; i < 100; i++ {
+		assert.LessOrEqual(t, len(tok.genPattern(randgen)), 5)
+	}
+	tok = Token{Type: "random", Replacement: "pattern", Pattern: `\d{16}`}
+	assert.NoError(t, tok.setupPattern())
+	assert.Len(t, tok.genPattern(randgen), 16)
+
+	for _, bad := range []string{"", "[a", "a{2000}", `[^\x00-\x{10FFFF}]`} {
+		tok := Token{Type: "random", Replacement: "pattern", Pattern: bad}
+		assert.Error(t, tok.setupPattern(), bad)
+	}
+}
@@ -1,6 +1,9 @@
 package internal
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // RaterConfig defines how to rate an event or token
 type RaterConfig struct {
@@ -9,6 +12,21 @@ type RaterConfig struct {
 	Script  string                 `json:"script,omitempty" yaml:"script,omitempty"`
 	Options map[string]interface{} `json:"options,omitempty" yaml:"options,omitempty"`
 	Init    map[string]string      `json:"init,omitempty" yaml:"init,omitempty"`
+
+	// Internal use variables
+	TimeSeries *TimeSeries    `json:"-" yaml:"-"` // Loaded from the file option of timeseries raters
+	calendar   []calendarDays // Holidays and DateRanges of config raters
+}
+
+// calendarDays is a holiday or date range of a config rater, from begin to end inclusive.  Days are
+// year*10000+month*100+day, or without a year, month*100+day, in which case they recur every year.  Date
+// ranges override the rater's other multipliers.
+type calendarDays struct {
+	begin      int
+	end        int
+	yearly     bool
+	override   bool
+	multiplier float64
 }
 
 // Rater will rate an event according to RaterConfig
@@ -16,3 +34,96 @@ type Rater interface {
 	EventRate(s *Sample, now time.Time, count int) float64
 	TokenRate(t Token, now time.Time) float64
 }
+
+// parseCalendarDay parses a day as YYYY-MM-DD, or MM-DD for a day every year
+func parseCalendarDay(v interface{}) (day int, yearly bool, err error) {
+	switch d := v.(type) {
+	case time.Time:
+		return d.Year()*10000 + int(d.Month())*100 + d.Day(), false, nil
+	case string:
+		if t, err := time.Parse("2006-01-02", d); err == nil {
+			return t.Year()*10000 + int(t.Month())*100 + t.Day(), false, nil
+		}
+		// Parse in a leap year so Feb 29 is allowed
+		if t, err := time.Parse("2006-01-02", "2000-"+d); err == nil {
+			return int(t.Month())*100 + t.Day(), true, nil
+		}
+	}
+	return 0, false, fmt.Errorf("day '%v' is not YYYY-MM-DD or MM-DD", v)
+}
+
+// parseCalendarEntry parses a holiday, with a date and multiplier, or a date range, with a begin, end
+// and multiplier
+func parseCalendarEntry(holiday bool, e interface{}) (cd calendarDays, err error) {
+	m := make(map[string]interface{})
+	switch v := e.(type) {
+	case map[interface{}]interface{}:
+		for k, v2 := range v {
+			m[fmt.Sprint(k)] = v2
+		}
+	case map[string]interface{}:
+		m = v
+	default:
+		return cd, fmt.Errorf("not an object")
+	}
+	var ok bool
+	if cd.multiplier, ok = toFloat(m["multiplier"]); !ok {
+		return cd, fmt.Errorf("multiplier is not a float or int")
+	}
+	var endYearly bool
+	if holiday {
+		if cd.begin, cd.yearly, err = parseCalendarDay(m["date"]); err != nil {
+			return cd, err
+		}
+		cd.end = cd.begin
+		return cd, nil
+	}
+	cd.override = true
+	if cd.begin, cd.yearly, err = parseCalendarDay(m["begin"]); err != nil {
+		return cd, err
+	}
+	if cd.end, endYearly, err = parseCalendarDay(m["end"]); err != nil {
+		return cd, err
+	}
+	if endYearly != cd.yearly {
+		return cd, fmt.Errorf("begin and end must both have a year or both not")
+	}
+	if !cd.yearly && cd.end < cd.begin {
+		return cd, fmt.Errorf("end is before begin")
+	}
+	return cd, nil
+}
+
+func (cd calendarDays) contains(now time.Time) bool {
+	day := int(now.Month())*100 + now.Day()
+	if !cd.yearly {
+		day += now.Year() * 10000
+	} else if cd.begin > cd.end {
+		// Wraps around the end of the year
+		return day >= cd.begin || day <= cd.end
+	}
+	return day >= cd.begin && day <= cd.end
+}
+
+// CalendarRate returns the product of the multipliers of the config rater's holidays which include now.  If
+// any date ranges include now, it instead returns the product of their multipliers and override is true, and
+// the rater's other multipliers don't apply.
+func (r *RaterConfig) CalendarRate(now time.Time) (rate float64, override bool) {
+	rate = 1.0
+	overrideRate := 1.0
+	for _, cd := range r.calendar {
+		if !cd.contains(now) {
+			continue
+		}
+		if cd.override {
+			override = true
+			overrideRate *= cd.multiplier
+		} else {
+			rate *= cd.multiplier
+		}
+	}
+	if override {
+		return overrideRate, true
+	}
+	return rate, false
+}
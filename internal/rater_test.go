@@ -77,3 +77,65 @@ func compareConfigRater(r1, r2 *RaterConfig, t *testing.T) {
 		}
 	}
 }
+
+func TestCalendarRaterConfig(t *testing.T) {
+	// Setup environment
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	home := ".."
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join(home, "tests", "rater", "calendarrater.yml"))
+
+	c := NewConfig()
+
+	r := getRater(c, "calendar")
+	assert.Equal(t, map[int]float64{1: 2.0}, r.Options["DayOfMonth"])
+	assert.Equal(t, map[int]float64{12: 1.5}, r.Options["MonthOfYear"])
+	// The holiday without a valid date and the range ending before it begins are skipped
+	assert.Equal(t, []calendarDays{
+		{begin: 1225, end: 1225, yearly: true, multiplier: 0.1},
+		{begin: 20011122, end: 20011122, multiplier: 0.2},
+		{begin: 20011123, end: 20011126, override: true, multiplier: 3},
+		{begin: 1231, end: 101, yearly: true, override: true, multiplier: 0.5},
+	}, r.calendar)
+}
+
+func TestCalendarRaterStringKeys(t *testing.T) {
+	// Configs parsed from JSON have string keys and lists of objects, and configs built in Go may use
+	// typed lists
+	c := &Config{}
+	r := &RaterConfig{
+		Name: "json",
+		Type: "config",
+		Options: map[string]interface{}{
+			"HourOfDay":  map[string]interface{}{"0": 2.0, "13": int64(3)},
+			"DayOfWeek":  map[string]interface{}{"x": 1.0},
+			"Holidays":   []map[string]interface{}{{"date": "12-25", "multiplier": 0.1}},
+			"DateRanges": []interface{}{map[string]interface{}{"begin": "11-23", "end": "11-26", "multiplier": 3.0}},
+		},
+	}
+	c.validateRater(r)
+	assert.Equal(t, map[int]float64{0: 2, 13: 3}, r.Options["HourOfDay"])
+	// Invalid options are skipped rather than panicking
+	assert.NotContains(t, r.Options, "DayOfWeek")
+	assert.Equal(t, []calendarDays{
+		{begin: 1225, end: 1225, yearly: true, multiplier: 0.1},
+		{begin: 1123, end: 1126, yearly: true, override: true, multiplier: 3},
+	}, r.calendar)
+}
+
+func TestParseCalendarEntry(t *testing.T) {
+	_, err := parseCalendarEntry(true, "12-25")
+	assert.Error(t, err)
+	_, err = parseCalendarEntry(true, map[string]interface{}{"date": "12-25"})
+	assert.Error(t, err)
+	_, err = parseCalendarEntry(true, map[string]interface{}{"date": "13-01", "multiplier": 1.0})
+	assert.Error(t, err)
+	_, err = parseCalendarEntry(false, map[string]interface{}{"begin": "2001-12-01", "end": "12-31", "multiplier": 1.0})
+	assert.Error(t, err)
+	cd, err := parseCalendarEntry(true, map[string]interface{}{"date": "02-29", "multiplier": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, calendarDays{begin: 229, end: 229, yearly: true, multiplier: 2}, cd)
+	cd, err = parseCalendarEntry(false, map[string]interface{}{"begin": "11-23", "end": "11-26", "multiplier": int64(3)})
+	assert.NoError(t, err)
+	assert.Equal(t, calendarDays{begin: 1123, end: 1126, yearly: true, override: true, multiplier: 3}, cd)
+}
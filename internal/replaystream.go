@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/coccyx/gogen/logger"
+)
+
+const maxReplayLineSize = 10 * 1024 * 1024
+
+// ReplayStream reads a replay file one line at a time instead of loading it into the sample, computing the gap
+// between events from the timestamp token as it goes.  Gzip compressed files are detected and decompressed.
+// The file isn't opened until the first call to Next, so only generating opens it.  Only the timer for the
+// sample reads from the stream, so it is not safe for concurrent use.
+type ReplayStream struct {
+	path  string
+	loop  bool
+	token Token
+
+	started  bool
+	file     *os.File
+	scanner  *bufio.Scanner
+	next     map[string]string
+	nextTs   time.Time
+	lastTs   time.Time
+	gapTotal time.Duration // Sum of the gaps seen so far, for the average gap after the last line
+	gaps     int
+}
+
+// NewReplayStream returns a stream of path for replay, using the timestamp token t to compute offsets.  If loop
+// is set, the file is reopened at EOF, otherwise Next returns io.EOF after the last line.
+func NewReplayStream(path string, loop bool, t Token) *ReplayStream {
+	return &ReplayStream{path: path, loop: loop, token: t}
+}
+
+// start opens the file the first time and checks its first line has a timestamp
+func (r *ReplayStream) start() error {
+	r.started = true
+	if err := r.open(); err != nil {
+		return err
+	}
+	if r.next == nil {
+		r.Close()
+		return fmt.Errorf("no lines in replay file '%s'", r.path)
+	}
+	if r.nextTs.IsZero() {
+		r.Close()
+		return fmt.Errorf("could not find timestamp for token '%s' in first line of replay file '%s'", r.token.Name, r.path)
+	}
+	return nil
+}
+
+// open opens the file from the beginning and reads the first line
+func (r *ReplayStream) open() error {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	reader, err := decompress(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error reading gzip replay file '%s': %s", r.path, err)
+	}
+	r.file = file
+	r.scanner = bufio.NewScanner(reader)
+	r.scanner.Buffer(make([]byte, 64*1024), maxReplayLineSize)
+	r.lastTs = time.Time{}
+	return r.read()
+}
+
+// decompress returns a reader decompressing r if it's gzip compressed, or reading it as is otherwise
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// read reads the next line into next, leaving it nil at EOF.  Lines without a timestamp, like the
+// continuation lines of a multiline event, take the timestamp of the line before them.
+func (r *ReplayStream) read() error {
+	r.next = nil
+	if !r.scanner.Scan() {
+		return r.scanner.Err()
+	}
+	r.next = map[string]string{"_raw": r.scanner.Text()}
+	r.nextTs = r.lastTs
+	if offsets, err := r.token.GetReplacementOffsets(r.next[r.token.Field]); err == nil && len(offsets) > 0 {
+		if ts, err := r.token.ParseTimestamp(r.next[r.token.Field][offsets[0][0]:offsets[0][1]]); err == nil {
+			r.nextTs = ts
+		} else {
+			log.Debugf("Error parsing timestamp in replay file '%s': %s", r.path, err)
+		}
+	}
+	r.lastTs = r.nextTs
+	return nil
+}
+
+// Next returns the next line and how long to wait before the line after it.  The last line of the file
+// waits for the average gap seen so far.  Returns io.EOF once the file is exhausted and not looping.
+func (r *ReplayStream) Next() (map[string]string, time.Duration, error) {
+	if !r.started {
+		if err := r.start(); err != nil {
+			return nil, 0, err
+		}
+	}
+	if r.next == nil {
+		return nil, 0, io.EOF
+	}
+	line, ts := r.next, r.nextTs
+	if err := r.read(); err != nil {
+		r.Close()
+		return nil, 0, fmt.Errorf("error reading replay file '%s': %s", r.path, err)
+	}
+	if r.next == nil {
+		r.Close()
+		if r.loop {
+			if err := r.open(); err != nil {
+				return nil, 0, fmt.Errorf("error reopening replay file '%s': %s", r.path, err)
+			}
+		}
+		var avg time.Duration
+		if r.gaps > 0 {
+			avg = r.gapTotal / time.Duration(r.gaps)
+		}
+		return line, avg, nil
+	}
+	gap := r.nextTs.Sub(ts)
+	if gap < 0 || ts.IsZero() {
+		gap = 0
+	}
+	r.gapTotal += gap
+	r.gaps++
+	return line, gap, nil
+}
+
+// Close closes the underlying file
+func (r *ReplayStream) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -5,14 +5,16 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"net/netip"
 	"regexp"
+	"regexp/syntax"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	_ "time/tzdata" // Timezones must load even where the system has no zoneinfo
 
 	strftime "github.com/cactus/gostrftime"
-	log "github.com/coccyx/gogen/logger"
 	"github.com/pbnjay/strptime"
 	uuid "github.com/satori/go.uuid"
 	lua "github.com/yuin/gopher-lua"
@@ -21,6 +23,12 @@ import (
 const randStringLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 const randHexLetters = "ABCDEF0123456789"
 
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
 // Sample is the main configuration data structure which is passed around through Gogen
 // Publicly exported options are brought in through YAML or JSON configs, and some state is maintained in private unexposed variables.
 type Sample struct {
@@ -45,24 +53,38 @@ type Sample struct {
 	Field           string              `json:"field,omitempty" yaml:"field,omitempty"`
 	FromSample      string              `json:"fromSample,omitempty" yaml:"fromSample,omitempty"`
 	SinglePass      bool                `json:"singlepass,omitempty" yaml:"singlepass,omitempty"`
+	SpreadTime      string              `json:"spreadTime,omitempty" yaml:"spreadTime,omitempty"`
+	Timezone        string              `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	ReplaySpeed     float64             `json:"replaySpeed,omitempty" yaml:"replaySpeed,omitempty"`
+	ReplayMaxGap    string              `json:"replayMaxGap,omitempty" yaml:"replayMaxGap,omitempty"`
+	ReplayFile      string              `json:"replayFile,omitempty" yaml:"replayFile,omitempty"`
+	ReplayEOF       string              `json:"replayEOF,omitempty" yaml:"replayEOF,omitempty"`
+	Scenario        *Scenario           `json:"scenario,omitempty" yaml:"scenario,omitempty"`
 
 	// Internal use variables
-	Rater           Rater                        `json:"-" yaml:"-"`
-	Output          *Output                      `json:"-" yaml:"-"`
-	EarliestParsed  time.Duration                `json:"-" yaml:"-"`
-	LatestParsed    time.Duration                `json:"-" yaml:"-"`
-	BeginParsed     time.Time                    `json:"-" yaml:"-"`
-	EndParsed       time.Time                    `json:"-" yaml:"-"`
-	Current         time.Time                    `json:"-" yaml:"-"` // If we are backfilling or generating for a specified time window, what time is it?
-	Realtime        bool                         `json:"-" yaml:"-"` // Are we done doing batch backfill or specified time window?
-	Wait            bool                         `json:"-" yaml:"-"`
-	BrokenLines     []map[string][]StringOrToken `json:"-" yaml:"-"`
-	ReplayOffsets   []time.Duration              `json:"-" yaml:"-"`
-	CustomGenerator *GeneratorConfig             `json:"-" yaml:"-"`
-	GeneratorState  *GeneratorState              `json:"-" yaml:"-"`
-	LuaMutex        *sync.Mutex                  `json:"-" yaml:"-"`
-	Buf             *bytes.Buffer                `json:"-" yaml:"-"`
-	realSample      bool                         // Used to represent samples which aren't just used to store lines from CSV or raw
+	Rater              Rater                        `json:"-" yaml:"-"`
+	Rand               *rand.Rand                   `json:"-" yaml:"-"` // Only set when a global seed is configured
+	Output             *Output                      `json:"-" yaml:"-"`
+	EarliestParsed     time.Duration                `json:"-" yaml:"-"`
+	LatestParsed       time.Duration                `json:"-" yaml:"-"`
+	BeginParsed        time.Time                    `json:"-" yaml:"-"`
+	EndParsed          time.Time                    `json:"-" yaml:"-"`
+	Current            time.Time                    `json:"-" yaml:"-"` // If we are backfilling or generating for a specified time window, what time is it?
+	Realtime           bool                         `json:"-" yaml:"-"` // Are we done doing batch backfill or specified time window?
+	Wait               bool                         `json:"-" yaml:"-"`
+	BrokenLines        []map[string][]StringOrToken `json:"-" yaml:"-"`
+	TokenOrder         []int                        `json:"-" yaml:"-"` // Dependency order of tokens, only set when the sample has expression tokens
+	LinePlans          []LinePlan                   `json:"-" yaml:"-"` // Precompiled replacements for each line, only set when not SinglePass
+	ReplayOffsets      []time.Duration              `json:"-" yaml:"-"`
+	ReplayMaxGapParsed time.Duration                `json:"-" yaml:"-"`
+	ReplayStream       *ReplayStream                `json:"-" yaml:"-"` // Only set when streaming from ReplayFile
+	CustomGenerator    *GeneratorConfig             `json:"-" yaml:"-"`
+	GeneratorState     *GeneratorState              `json:"-" yaml:"-"`
+	LuaMutex           *sync.Mutex                  `json:"-" yaml:"-"`
+	Buf                *bytes.Buffer                `json:"-" yaml:"-"`
+	realSample         bool                         // Used to represent samples which aren't just used to store lines from CSV or raw
+	cfg                *Config                      // Config the sample was built in
+	anomalies          []*sampleAnomaly             // Anomalies scheduled for the sample
 }
 
 // Clock allows for implementers to keep track of their own view
@@ -84,6 +106,30 @@ func (s *Sample) Now() time.Time {
 	return time.Now()
 }
 
+// FindRater returns a RaterConfig matched by name from the config the sample was built in
+func (s *Sample) FindRater(name string) *RaterConfig {
+	if s.cfg == nil {
+		return NewConfig().FindRater(name)
+	}
+	return s.cfg.FindRater(name)
+}
+
+// ReplayGap returns how long to wait after replaying event i, sped up by ReplaySpeed and capped at ReplayMaxGap
+func (s *Sample) ReplayGap(i int) time.Duration {
+	return s.ScaleReplayGap(s.ReplayOffsets[i])
+}
+
+// ScaleReplayGap speeds up gap by ReplaySpeed and caps it at ReplayMaxGap
+func (s *Sample) ScaleReplayGap(gap time.Duration) time.Duration {
+	if s.ReplaySpeed > 0 {
+		gap = time.Duration(float64(gap) / s.ReplaySpeed)
+	}
+	if s.ReplayMaxGapParsed > 0 && gap > s.ReplayMaxGapParsed {
+		gap = s.ReplayMaxGapParsed
+	}
+	return gap
+}
+
 // Token describes a replacement task to run against a sample
 type Token struct {
 	Name           string              `json:"name" yaml:"name"`
@@ -91,30 +137,57 @@ type Token struct {
 	Token          string              `json:"token" yaml:"token"`
 	Type           string              `json:"type" yaml:"type"`
 	Replacement    string              `json:"replacement,omitempty" yaml:"replacement,omitempty"`
+	Timezone       string              `json:"timezone,omitempty" yaml:"timezone,omitempty"`
 	Group          int                 `json:"group,omitempty" yaml:"group,omitempty"`
 	Sample         *Sample             `json:"-" yaml:"-"`
 	Parent         *Sample             `json:"-" yaml:"-"`
 	SampleString   string              `json:"sample,omitempty" yaml:"sample,omitempty"`
 	Field          string              `json:"field,omitempty" yaml:"field,omitempty"`
 	SrcField       string              `json:"srcField,omitempty" yaml:"srcField,omitempty"`
+	Entity         string              `json:"entity,omitempty" yaml:"entity,omitempty"`
 	Precision      int                 `json:"precision,omitempty" yaml:"precision,omitempty"`
 	Lower          int                 `json:"lower,omitempty" yaml:"lower,omitempty"`
 	Upper          int                 `json:"upper,omitempty" yaml:"upper,omitempty"`
 	Length         int                 `json:"length,omitempty" yaml:"length,omitempty"`
+	Pattern        string              `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Order          int                 `json:"order,omitempty" yaml:"order,omitempty"`
+	Distribution   *Distribution       `json:"distribution,omitempty" yaml:"distribution,omitempty"`
+	CIDRs          []WeightedCIDR      `json:"cidrs,omitempty" yaml:"cidrs,omitempty"`
+	IPMode         string              `json:"ipMode,omitempty" yaml:"ipMode,omitempty"`
 	WeightedChoice []WeightedChoice    `json:"weightedChoice,omitempty" yaml:"weightedChoice,omitempty"`
 	FieldChoice    []map[string]string `json:"fieldChoice,omitempty" yaml:"fieldChoice,omitempty"`
 	Choice         []string            `json:"choice,omitempty" yaml:"choice,omitempty"`
+	Start          int64               `json:"start,omitempty" yaml:"start,omitempty"`
+	Step           int64               `json:"step,omitempty" yaml:"step,omitempty"`
+	Padding        int                 `json:"padding,omitempty" yaml:"padding,omitempty"`
+	Wrap           int64               `json:"wrap,omitempty" yaml:"wrap,omitempty"`
+	StateFile      string              `json:"stateFile,omitempty" yaml:"stateFile,omitempty"`
 	Script         string              `json:"script,omitempty" yaml:"script,omitempty"`
 	Init           map[string]string   `json:"init,omitempty" yaml:"init,omitempty"`
 	RaterString    string              `json:"rater,omitempty" yaml:"rater,omitempty"`
 	Disabled       bool                `json:"disabled,omitempty" yaml:"disabled,omitempty"`
 	Rater          Rater               `json:"-" yaml:"-"`
+	ExprValue      bool                `json:"-" yaml:"-"` // Generated once per event, before replacement, for the expressions referencing it
 
 	L                          *lua.LState `json:"-" yaml:"-"`
 	luaState                   *lua.LTable
-	mutex                      *sync.Mutex
+	luaScript                  *LuaScript
+	location                   *time.Location
+	pattern                    *syntax.Regexp
+	markov                     *markovChain
 	weightedChoiceTotals       []int
 	weightedChoiceRunningTotal int
+	cidrPrefixes               []netip.Prefix
+	cidrTotals                 []int
+	cidrRunningTotal           int
+	cidrConforming             []netip.Prefix
+	cidrConformingTotals       []float64
+	cidrConformingTotal        float64
+	sequence                   *sequence
+	entity                     *EntityConfig
+	expr                       exprNode
+	re                         *regexp.Regexp
+	exprDeps                   []string
 }
 
 // WeightedChoice is a simple data structure for allowing a list of items with a Choice to pick and a Weight for that choice
@@ -135,6 +208,15 @@ func (tp tokenspos) Len() int           { return len(tp) }
 func (tp tokenspos) Less(i, j int) bool { return tp[i].Pos1 < tp[j].Pos2 }
 func (tp tokenspos) Swap(i, j int)      { tp[i], tp[j] = tp[j], tp[i] }
 
+// LinePlan is the precompiled replacement plan for one line of a sample which can't be SinglePass.
+// Fields where all tokens can be located without overlapping are broken up just like SinglePass,
+// and the remaining fields list the tokens to replace one after another.
+type LinePlan struct {
+	Broken  map[string][]StringOrToken
+	Dynamic map[string][]*Token
+	Fields  []string // Fields in the order multipass finishes them, by their last token, with _channel last
+}
+
 // StringOrToken is used for SinglePass and stores either a string or a token
 type StringOrToken struct {
 	S string
@@ -145,27 +227,51 @@ type StringOrToken struct {
 // earliest and latest time ranges to generate the event between.  Lastly, some times we want to span a selected choice over multiple
 // tokens.  Passing in a pointer to choice allows the replacement to choose a preselected row in FieldChoice or Choice.
 func (t Token) Replace(event *string, choice int, et time.Time, lt time.Time, now time.Time, randgen *rand.Rand, fullevent map[string]string) (int, error) {
-	// s := t.Sample
 	e := *event
 
-	if offsets, err := t.GetReplacementOffsets(*event); err != nil {
+	offsets, err := t.GetReplacementOffsets(e)
+	if err != nil {
 		return choice, nil
-	} else {
-		retchoice := choice
-		lastoffset := 0
-		*event = ""
-		for _, match := range offsets {
-			replacement, newchoice, err := t.GenReplacement(retchoice, et, lt, now, randgen, fullevent)
-			if err != nil {
-				return -1, err
-			}
-			*event = *event + e[lastoffset:match[0]] + replacement
-			retchoice = newchoice
-			lastoffset = match[1]
+	}
+	retchoice := choice
+	lastoffset := 0
+	b := bufPool.Get().(*bytes.Buffer)
+	b.Reset()
+	defer bufPool.Put(b)
+	for _, match := range offsets {
+		replacement, newchoice, err := t.GenReplacement(retchoice, et, lt, now, randgen, fullevent)
+		if err != nil {
+			return -1, err
 		}
-		*event += e[lastoffset:]
-		return retchoice, nil
+		b.WriteString(e[lastoffset:match[0]])
+		b.WriteString(replacement)
+		retchoice = newchoice
+		lastoffset = match[1]
 	}
+	b.WriteString(e[lastoffset:])
+	*event = b.String()
+	return retchoice, nil
+}
+
+// ReplaceValue replaces all instances of this token in the string pointed to by event with an already generated value.
+// This is used for expression tokens and the tokens they reference, which are generated once per event.
+func (t Token) ReplaceValue(event *string, value string) {
+	e := *event
+	offsets, err := t.GetReplacementOffsets(e)
+	if err != nil {
+		return
+	}
+	b := bufPool.Get().(*bytes.Buffer)
+	b.Reset()
+	defer bufPool.Put(b)
+	lastoffset := 0
+	for _, match := range offsets {
+		b.WriteString(e[lastoffset:match[0]])
+		b.WriteString(value)
+		lastoffset = match[1]
+	}
+	b.WriteString(e[lastoffset:])
+	*event = b.String()
 }
 
 // GetReplacementOffsets returns the beginning and end of a token inside an event string
@@ -183,9 +289,12 @@ func (t Token) GetReplacementOffsets(event string) ([][]int, error) {
 			offset += pos + len(t.Token)
 		}
 	case "regex":
-		re, err := regexp.Compile(t.Token)
-		if err != nil {
-			return ret, err
+		re := t.re
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile(t.Token); err != nil {
+				return ret, err
+			}
 		}
 		matches := re.FindAllStringSubmatchIndex(event, -1)
 		if matches != nil {
@@ -205,6 +314,13 @@ func (t Token) GetReplacementOffsets(event string) ([][]int, error) {
 // GenReplacement generates a replacement value for the token.  choice allows the user to specify
 // a specific value to choose in the array.  This is useful for saving picks amongst tokens.
 func (t Token) GenReplacement(choice int, et time.Time, lt time.Time, now time.Time, randgen *rand.Rand, fullevent map[string]string) (string, int, error) {
+	if t.Parent != nil && t.Parent.anomalies != nil {
+		// A distribution anomaly replaces the token while it's active, leaving the choice for its group alone
+		if o := t.Parent.anomalyToken(t.Name, now); o != nil {
+			replacement, _, err := o.GenReplacement(-1, et, lt, now, randgen, fullevent)
+			return replacement, choice, err
+		}
+	}
 	switch t.Type {
 	case "timestamp", "gotimestamp", "epochtimestamp":
 		td := lt.Sub(et)
@@ -215,22 +331,30 @@ func (t Token) GenReplacement(choice int, et time.Time, lt time.Time, now time.T
 		}
 		rd := time.Duration(tdr)
 		replacementTime := lt.Add(rd * -1)
-		replacementTime = convertUTC(replacementTime)
+		replacementTime = t.convertTime(replacementTime)
 		switch t.Type {
 		case "timestamp":
 			return strftime.Format(t.Replacement, replacementTime), -1, nil
 		case "gotimestamp":
 			return replacementTime.Format(t.Replacement), -1, nil
 		case "epochtimestamp":
-			return strconv.FormatInt(replacementTime.Unix(), 10), -1, nil
+			return t.formatEpoch(replacementTime), -1, nil
 		}
 	case "static":
 		return t.Replacement, -1, nil
+	case "sequence":
+		ret, err := t.nextSequence()
+		if err != nil {
+			return "", -1, fmt.Errorf("Error persisting sequence for token '%s': %s", t.Name, err)
+		}
+		return ret, -1, nil
 	case "rated":
 		switch t.Replacement {
 		case "int":
 			var ret int
-			if (t.Upper - t.Lower) > 0 {
+			if t.Distribution != nil {
+				ret = t.Distribution.drawInt(randgen, t.Lower, t.Upper)
+			} else if (t.Upper - t.Lower) > 0 {
 				ret = randgen.Intn(t.Upper-t.Lower) + t.Lower
 			} else if (t.Upper - t.Lower) <= 0 {
 				ret = t.Upper
@@ -247,7 +371,9 @@ func (t Token) GenReplacement(choice int, et time.Time, lt time.Time, now time.T
 			lower := t.Lower * int(math.Pow10(t.Precision))
 			upper := t.Upper * int(math.Pow10(t.Precision))
 			var f float64
-			if (upper - lower) > 0 {
+			if t.Distribution != nil {
+				f = t.Distribution.draw(randgen, float64(t.Lower), float64(t.Upper))
+			} else if (upper - lower) > 0 {
 				f = float64(randgen.Intn(upper-lower)+lower) / math.Pow10(t.Precision)
 			} else {
 				f = float64(upper) / math.Pow10(t.Precision)
@@ -259,9 +385,17 @@ func (t Token) GenReplacement(choice int, et time.Time, lt time.Time, now time.T
 	case "random":
 		switch t.Replacement {
 		case "int":
+			if t.Distribution != nil {
+				ri := t.Distribution.drawInt(randgen, t.Lower, t.Upper)
+				return strconv.Itoa(ri), -1, nil
+			}
 			ri := randgen.Intn(t.Upper-t.Lower) + t.Lower
 			return strconv.Itoa(ri), -1, nil
 		case "float":
+			if t.Distribution != nil {
+				f := t.Distribution.draw(randgen, float64(t.Lower), float64(t.Upper))
+				return strconv.FormatFloat(f, 'f', t.Precision, 64), -1, nil
+			}
 			lower := t.Lower * int(math.Pow10(t.Precision))
 			upper := t.Upper * int(math.Pow10(t.Precision))
 			f := float64(randgen.Intn(upper-lower)+lower) / math.Pow10(t.Precision)
@@ -277,10 +411,18 @@ func (t Token) GenReplacement(choice int, et time.Time, lt time.Time, now time.T
 				b.WriteByte(letters[randgen.Intn(len(letters))])
 			}
 			return b.String(), -1, nil
+		case "pattern":
+			return t.genPattern(randgen), -1, nil
 		case "guid":
-			u := uuid.NewV4()
+			var u uuid.UUID
+			randgen.Read(u[:])
+			u.SetVersion(uuid.V4)
+			u.SetVariant(uuid.VariantRFC4122)
 			return u.String(), -1, nil
 		case "ipv4":
+			if t.cidrRunningTotal > 0 {
+				return t.genIP(randgen), -1, nil
+			}
 			var b strings.Builder
 			b.Grow(15) // max "255.255.255.255"
 			for i := 0; i < 4; i++ {
@@ -291,6 +433,9 @@ func (t Token) GenReplacement(choice int, et time.Time, lt time.Time, now time.T
 			}
 			return b.String(), -1, nil
 		case "ipv6":
+			if t.cidrRunningTotal > 0 {
+				return t.genIP(randgen), -1, nil
+			}
 			var b strings.Builder
 			b.Grow(39) // max "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"
 			for i := 0; i < 8; i++ {
@@ -330,6 +475,8 @@ func (t Token) GenReplacement(choice int, et time.Time, lt time.Time, now time.T
 			return "", -1, fmt.Errorf("Choice out of range")
 		}
 		return t.WeightedChoice[choice].Choice, choice, nil
+	case "markov":
+		return t.genMarkov(randgen), -1, nil
 	case "fieldChoice":
 		if choice == -1 {
 			choice = randgen.Intn(len(t.FieldChoice))
@@ -337,16 +484,28 @@ func (t Token) GenReplacement(choice int, et time.Time, lt time.Time, now time.T
 			return "", -1, fmt.Errorf("Choice out of range")
 		}
 		return t.FieldChoice[choice][t.SrcField], choice, nil
+	case "entity":
+		entities := t.entity.Entities
+		if len(entities) == 0 {
+			return "", -1, fmt.Errorf("Entity '%s' has no entities for token '%s'", t.Entity, t.Name)
+		}
+		if choice == -1 {
+			choice = randgen.Intn(len(entities))
+		} else if choice < 0 || choice >= len(entities) {
+			return "", -1, fmt.Errorf("Choice out of range")
+		}
+		return entities[choice][t.SrcField], choice, nil
+	case "expression":
+		replacement, err := t.Evaluate(nil, fullevent)
+		return replacement, -1, err
 	case "script":
-		t.mutex.Lock()
-		defer t.mutex.Unlock()
-		L := lua.NewState()
-		defer L.Close()
-		L.SetGlobal("state", t.luaState)
-		if err := L.DoString(t.Script); err != nil {
-			log.Errorf("Error executing script for token '%s' in sample '%s': %s", t.Name, t.Parent.Name, err)
+		ret, err := t.luaScript.Run(func(L *lua.LState) {
+			SetLuaRand(L, randgen)
+		})
+		if err != nil {
+			return "", -1, fmt.Errorf("Error executing script for token '%s' in sample '%s': %s", t.Name, t.Parent.Name, err)
 		}
-		return lua.LVAsString(L.Get(-1)), -1, nil
+		return lua.LVAsString(ret), -1, nil
 	case "_channel":
 		channelConfStr := strings.Join([]string{"host::", fullevent["host"], "|source::", fullevent["source"], "|", fullevent["sourcetype"], "|"}, "")
 		var chanIdx int
@@ -363,7 +522,8 @@ func (t Token) GenReplacement(choice int, et time.Time, lt time.Time, now time.T
 	return "", -1, fmt.Errorf("GenReplacement called with invalid type for token '%s' with type '%s'", t.Name, t.Type)
 }
 
-// ParseTimestamp will return a time.Time based on the configured token's setup
+// ParseTimestamp will return a time.Time based on the configured token's setup.  Timestamps without
+// a zone are read in the token's timezone, if configured.
 func (t Token) ParseTimestamp(eventts string) (time.Time, error) {
 	switch t.Type {
 	case "timestamp":
@@ -371,21 +531,54 @@ func (t Token) ParseTimestamp(eventts string) (time.Time, error) {
 		if err != nil {
 			return time.Time{}, err
 		}
+		if t.location != nil {
+			ts = time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), t.location)
+		}
 		return ts, nil
 	case "gotimestamp":
-		ts, err := time.Parse(t.Replacement, eventts)
-		if err != nil {
-			return time.Time{}, err
+		loc := t.location
+		if loc == nil {
+			loc = time.UTC
 		}
-		return ts, nil
-	case "epochtimestamp":
-		tsi, err := strconv.ParseInt(eventts, 10, 64)
+		ts, err := time.ParseInLocation(t.Replacement, eventts, loc)
 		if err != nil {
 			return time.Time{}, err
 		}
-		ts := time.Unix(tsi, 0)
 		return ts, nil
+	case "epochtimestamp":
+		return t.parseEpoch(eventts)
 	default:
 		return time.Time{}, fmt.Errorf("Token not a timestamp token")
 	}
 }
+
+// setupTimezone loads the token's timezone, or if unset the sample's, for timestamp tokens
+func (t *Token) setupTimezone(sampleTimezone string) error {
+	tz := t.Timezone
+	if tz == "" {
+		tz = sampleTimezone
+	}
+	if tz == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return err
+	}
+	t.location = loc
+	return nil
+}
+
+// convertTime puts a generated time in the token's timezone, otherwise in UTC if configured
+func (t Token) convertTime(ts time.Time) time.Time {
+	if t.location != nil {
+		return ts.In(t.location)
+	}
+	if t.Parent != nil && t.Parent.cfg != nil {
+		if t.Parent.cfg.Global.UTC {
+			return ts.UTC()
+		}
+		return ts
+	}
+	return convertUTC(ts)
+}
@@ -156,6 +156,7 @@ func testToken(i int, value string, s *Sample, t *testing.T) {
 func TestLuaReplacement(t *testing.T) {
 	os.Setenv("GOGEN_HOME", "..")
 	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	os.Setenv("GOGEN_FULLCONFIG", "")
 	home := ".."
 	os.Setenv("GOGEN_SAMPLES_DIR", filepath.Join(home, "tests", "tokens", "lua.yml"))
 
@@ -167,6 +168,9 @@ func TestLuaReplacement(t *testing.T) {
 	testToken(2, "0.945", s, t)
 	testToken(3, "NvofsbSj4", s, t)
 	testToken(4, "4C345", s, t)
+	// Init values which are numbers are set as numbers
+	testToken(5, "n11", s, t)
+	testToken(5, "n12", s, t)
 }
 
 func TestParseTimestamp(t *testing.T) {
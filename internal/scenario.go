@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxScenarioSteps bounds how many states one instance moves through in a single Advance, so loops
+// without delays can't stall the generator.  The rest of the steps are taken by the next Advance.
+const maxScenarioSteps = 1000
+
+// Scenario is a state machine for the scenario generator.  Every instance of the scenario starts in the
+// Start state, emits that state's lines, and then follows one of its transitions after a delay, until it
+// reaches a state it can't leave.
+type Scenario struct {
+	Start  string          `json:"start,omitempty" yaml:"start,omitempty"`
+	Vars   []string        `json:"vars,omitempty" yaml:"vars,omitempty"`
+	States []ScenarioState `json:"states" yaml:"states"`
+
+	start     *ScenarioState
+	mutex     sync.Mutex
+	instances []*scenarioInstance
+	finished  bool
+}
+
+// ScenarioState is one state of a Scenario.  Its lines are emitted Repeat times, waiting between Delay
+// and MaxDelay between repeats, before taking a transition.
+type ScenarioState struct {
+	Name        string               `json:"name" yaml:"name"`
+	Lines       []map[string]string  `json:"lines,omitempty" yaml:"lines,omitempty"`
+	Repeat      int                  `json:"repeat,omitempty" yaml:"repeat,omitempty"`
+	Delay       string               `json:"delay,omitempty" yaml:"delay,omitempty"`
+	MaxDelay    string               `json:"maxDelay,omitempty" yaml:"maxDelay,omitempty"`
+	Transitions []ScenarioTransition `json:"transitions,omitempty" yaml:"transitions,omitempty"`
+
+	delay    time.Duration
+	maxDelay time.Duration
+}
+
+// ScenarioTransition moves an instance to the state To, waiting between Delay and MaxDelay.  If no
+// transition of a state sets a Probability, one is picked at random.  Otherwise, each is taken with its
+// probability, and the rest of the time the instance ends.
+type ScenarioTransition struct {
+	To          string  `json:"to" yaml:"to"`
+	Probability float64 `json:"probability,omitempty" yaml:"probability,omitempty"`
+	Delay       string  `json:"delay,omitempty" yaml:"delay,omitempty"`
+	MaxDelay    string  `json:"maxDelay,omitempty" yaml:"maxDelay,omitempty"`
+
+	to       *ScenarioState
+	delay    time.Duration
+	maxDelay time.Duration
+}
+
+// ScenarioEvent is a state reached by an instance of a scenario.  The state's lines should be generated at
+// Time, with the instance's Vars in place of the tokens they name.
+type ScenarioEvent struct {
+	Time  time.Time
+	State *ScenarioState
+	Vars  map[string]string
+}
+
+// scenarioInstance is one run through a scenario, with its own variables
+type scenarioInstance struct {
+	state   *ScenarioState
+	vars    map[string]string
+	next    time.Time
+	repeats int
+}
+
+// clone returns a copy of the scenario's definition without any instances, for samples copied with fromSample
+func (sc *Scenario) clone() *Scenario {
+	states := make([]ScenarioState, len(sc.States))
+	for i, st := range sc.States {
+		states[i] = st
+		states[i].Transitions = append([]ScenarioTransition(nil), st.Transitions...)
+	}
+	return &Scenario{Start: sc.Start, Vars: sc.Vars, States: states}
+}
+
+// setup links states and transitions and parses delays
+func (sc *Scenario) setup() error {
+	if len(sc.States) == 0 {
+		return fmt.Errorf("no states in scenario")
+	}
+	states := make(map[string]*ScenarioState, len(sc.States))
+	for i := range sc.States {
+		st := &sc.States[i]
+		if st.Name == "" {
+			return fmt.Errorf("state %d has no name", i)
+		}
+		if _, ok := states[st.Name]; ok {
+			return fmt.Errorf("state '%s' is defined twice", st.Name)
+		}
+		if st.Repeat < 0 {
+			return fmt.Errorf("repeat cannot be negative for state '%s'", st.Name)
+		}
+		if st.Repeat == 0 {
+			st.Repeat = 1
+		}
+		var err error
+		if st.delay, st.maxDelay, err = parseScenarioDelay(st.Delay, st.MaxDelay); err != nil {
+			return fmt.Errorf("invalid delay for state '%s': %s", st.Name, err)
+		}
+		states[st.Name] = st
+	}
+	for i := range sc.States {
+		st := &sc.States[i]
+		total := 0.0
+		for j := range st.Transitions {
+			tr := &st.Transitions[j]
+			if tr.to = states[tr.To]; tr.to == nil {
+				return fmt.Errorf("state '%s' has a transition to unknown state '%s'", st.Name, tr.To)
+			}
+			if tr.Probability < 0 || tr.Probability > 1 {
+				return fmt.Errorf("probability must be between 0 and 1 for transition from '%s' to '%s'", st.Name, tr.To)
+			}
+			total += tr.Probability
+			var err error
+			if tr.delay, tr.maxDelay, err = parseScenarioDelay(tr.Delay, tr.MaxDelay); err != nil {
+				return fmt.Errorf("invalid delay for transition from '%s' to '%s': %s", st.Name, tr.To, err)
+			}
+		}
+		if total > 1.000001 {
+			return fmt.Errorf("probabilities of transitions from state '%s' add up to more than 1", st.Name)
+		}
+	}
+	if sc.Start == "" {
+		sc.Start = sc.States[0].Name
+	}
+	if sc.start = states[sc.Start]; sc.start == nil {
+		return fmt.Errorf("start state '%s' not found", sc.Start)
+	}
+	return nil
+}
+
+func parseScenarioDelay(delay, maxDelay string) (time.Duration, time.Duration, error) {
+	var min, max time.Duration
+	var err error
+	if delay != "" {
+		if min, err = time.ParseDuration(delay); err != nil {
+			return 0, 0, err
+		}
+	}
+	max = min
+	if maxDelay != "" {
+		if max, err = time.ParseDuration(maxDelay); err != nil {
+			return 0, 0, err
+		}
+	}
+	if min < 0 || max < min {
+		return 0, 0, fmt.Errorf("delay must not be negative or greater than maxDelay")
+	}
+	return min, max, nil
+}
+
+func randDelay(min, max time.Duration, randgen *rand.Rand) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(randgen.Int63n(int64(max-min)+1))
+}
+
+// StartInstance starts a new instance of the scenario at t with its own variables
+func (sc *Scenario) StartInstance(t time.Time, vars map[string]string) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.instances = append(sc.instances, &scenarioInstance{state: sc.start, vars: vars, next: t})
+}
+
+// Instances returns the number of instances which haven't finished
+func (sc *Scenario) Instances() int {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return len(sc.instances)
+}
+
+// Finish marks the scenario's last interval as generated, so every Advance from then on runs instances to
+// the end, however the intervals before it were ordered among generator workers
+func (sc *Scenario) Finish() {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.finished = true
+}
+
+// Advance moves every instance through its states up to, but not including, before, and returns the states
+// reached in time order.  Instances which finish are removed.  A zero before, or a finished scenario, runs
+// every instance to the end.
+func (sc *Scenario) Advance(before time.Time, randgen *rand.Rand) []ScenarioEvent {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if sc.finished {
+		before = time.Time{}
+	}
+	var events []ScenarioEvent
+	running := sc.instances[:0]
+	for _, inst := range sc.instances {
+		done := false
+		for steps := 0; steps < maxScenarioSteps && (before.IsZero() || inst.next.Before(before)); steps++ {
+			events = append(events, ScenarioEvent{Time: inst.next, State: inst.state, Vars: inst.vars})
+			if done = !inst.step(randgen); done {
+				break
+			}
+		}
+		if !done {
+			running = append(running, inst)
+		}
+	}
+	for i := len(running); i < len(sc.instances); i++ {
+		sc.instances[i] = nil
+	}
+	sc.instances = running
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events
+}
+
+// step moves the instance on after its state has emitted, returning false if the instance has finished
+func (inst *scenarioInstance) step(randgen *rand.Rand) bool {
+	st := inst.state
+	inst.repeats++
+	if inst.repeats < st.Repeat {
+		inst.next = inst.next.Add(randDelay(st.delay, st.maxDelay, randgen))
+		return true
+	}
+	if len(st.Transitions) == 0 {
+		return false
+	}
+	var tr *ScenarioTransition
+	weighted := false
+	for i := range st.Transitions {
+		weighted = weighted || st.Transitions[i].Probability > 0
+	}
+	if weighted {
+		r := randgen.Float64()
+		for i := range st.Transitions {
+			if r < st.Transitions[i].Probability {
+				tr = &st.Transitions[i]
+				break
+			}
+			r -= st.Transitions[i].Probability
+		}
+		if tr == nil {
+			return false
+		}
+	} else {
+		tr = &st.Transitions[randgen.Intn(len(st.Transitions))]
+	}
+	inst.state = tr.to
+	inst.repeats = 0
+	inst.next = inst.next.Add(randDelay(tr.delay, tr.maxDelay, randgen))
+	return true
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScenarioValidation(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	home := filepath.Join("..", "tests", "scenario")
+
+	checks := []string{
+		"no-scenario",
+		"bad-scenario-start",
+		"bad-scenario-transition",
+		"bad-scenario-probability",
+		"bad-scenario-delay",
+		"bad-scenario-var",
+	}
+	for _, v := range checks {
+		s := FindSampleInFile(home, v)
+		assert.Nil(t, s, "%s not nil", v)
+	}
+
+	s := FindSampleInFile(home, "bruteforce")
+	if !assert.NotNil(t, s) {
+		return
+	}
+	assert.Equal(t, "failed", s.Scenario.Start)
+	assert.Equal(t, 5, s.Scenario.States[0].Repeat)
+	assert.Equal(t, 1, s.Scenario.States[1].Repeat)
+}
+
+func TestScenarioAdvance(t *testing.T) {
+	sc := &Scenario{States: []ScenarioState{
+		{Name: "failed", Repeat: 3, Delay: "2s", Transitions: []ScenarioTransition{{To: "success", Delay: "1s"}}},
+		{Name: "success"},
+	}}
+	assert.NoError(t, sc.setup())
+
+	randgen := rand.New(rand.NewSource(0))
+	n := time.Date(2001, 10, 20, 12, 0, 0, 0, time.UTC)
+	sc.StartInstance(n, map[string]string{"user": "alice"})
+	sc.StartInstance(n.Add(time.Second), map[string]string{"user": "bob"})
+
+	events := sc.Advance(n.Add(4*time.Second), randgen)
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Time.Sub(n).String()+" "+e.State.Name+" "+e.Vars["user"])
+	}
+	assert.Equal(t, []string{"0s failed alice", "1s failed bob", "2s failed alice", "3s failed bob"}, got)
+	assert.Equal(t, 2, sc.Instances())
+
+	// Zero runs every instance to the end
+	got = nil
+	for _, e := range sc.Advance(time.Time{}, randgen) {
+		got = append(got, e.Time.Sub(n).String()+" "+e.State.Name+" "+e.Vars["user"])
+	}
+	assert.Equal(t, []string{"4s failed alice", "5s success alice", "5s failed bob", "6s success bob"}, got)
+	assert.Equal(t, 0, sc.Instances())
+}
+
+func TestScenarioFinish(t *testing.T) {
+	sc := &Scenario{States: []ScenarioState{
+		{Name: "failed", Repeat: 3, Delay: "2s"},
+	}}
+	assert.NoError(t, sc.setup())
+
+	randgen := rand.New(rand.NewSource(0))
+	n := time.Date(2001, 10, 20, 12, 0, 0, 0, time.UTC)
+	sc.StartInstance(n, nil)
+	sc.Finish()
+	// Instances started after the scenario is finished still run to the end
+	sc.StartInstance(n.Add(-time.Minute), nil)
+	assert.Len(t, sc.Advance(n, randgen), 6)
+	assert.Equal(t, 0, sc.Instances())
+}
+
+func TestScenarioProbability(t *testing.T) {
+	sc := &Scenario{States: []ScenarioState{
+		{Name: "start", Transitions: []ScenarioTransition{{To: "next", Probability: 0.25}}},
+		{Name: "next"},
+	}}
+	assert.NoError(t, sc.setup())
+
+	randgen := rand.New(rand.NewSource(0))
+	n := time.Now()
+	for i := 0; i < 1000; i++ {
+		sc.StartInstance(n, nil)
+	}
+	next := 0
+	for _, e := range sc.Advance(time.Time{}, randgen) {
+		if e.State.Name == "next" {
+			next++
+		}
+	}
+	assert.InDelta(t, 250, next, 50)
+}
+
+func TestScenarioLoopWithoutDelay(t *testing.T) {
+	sc := &Scenario{States: []ScenarioState{
+		{Name: "loop", Transitions: []ScenarioTransition{{To: "loop"}}},
+	}}
+	assert.NoError(t, sc.setup())
+
+	sc.StartInstance(time.Now(), nil)
+	assert.Len(t, sc.Advance(time.Time{}, rand.New(rand.NewSource(0))), maxScenarioSteps)
+	assert.Equal(t, 1, sc.Instances())
+}
@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"hash/fnv"
+	"math/rand"
+)
+
+// NewSeededRand returns a random generator whose source is derived from seed and key.  Keying by
+// sample name gives every sample its own stable stream, independent of scheduling and worker counts.
+func NewSeededRand(seed int64, key string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return rand.New(rand.NewSource(seed ^ int64(h.Sum64())))
+}
+
+// SetSeed sets the global random seed and reseeds every sample from it.  Entity pools are
+// regenerated from the new seed.  A seed of zero reverts to seeding from the current time.
+func (c *Config) SetSeed(seed int64) {
+	c.Global.Seed = seed
+	for _, e := range c.Entities {
+		e.generate(seed)
+	}
+	for _, s := range c.Samples {
+		if seed == 0 {
+			s.Rand = nil
+		} else {
+			s.Rand = NewSeededRand(seed, s.Name)
+		}
+	}
+}
+
+// ItemRand returns a fresh random generator for one unit of work for the sample, or nil if the
+// sample is not seeded.  Each call advances the sample's stream, so callers must draw items in a
+// deterministic order.
+func (s *Sample) ItemRand() *rand.Rand {
+	if s.Rand == nil {
+		return nil
+	}
+	return rand.New(rand.NewSource(s.Rand.Int63()))
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSeededRand(t *testing.T) {
+	a := NewSeededRand(42, "foo")
+	b := NewSeededRand(42, "foo")
+	c := NewSeededRand(42, "bar")
+	av, bv, cv := a.Int63(), b.Int63(), c.Int63()
+	assert.Equal(t, av, bv)
+	assert.NotEqual(t, av, cv)
+}
+
+func TestSetSeed(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	os.Setenv("GOGEN_FULLCONFIG", "")
+	os.Setenv("GOGEN_SAMPLES_DIR", filepath.Join("..", "tests", "tokens", "tokens.yml"))
+
+	c := NewConfig()
+	s := c.FindSampleByName("tokens")
+	assert.Nil(t, s.Rand)
+	assert.Nil(t, s.ItemRand())
+
+	gen := func() []string {
+		c.SetSeed(1234)
+		ret := make([]string, 0)
+		n := time.Date(2001, 10, 20, 12, 0, 0, 0, time.UTC)
+		for i := 0; i < 3; i++ {
+			randgen := s.ItemRand()
+			for _, tok := range s.Tokens {
+				r, _, err := tok.GenReplacement(-1, n.Add(-time.Hour), n, n, randgen, map[string]string{})
+				assert.NoError(t, err)
+				ret = append(ret, r)
+			}
+		}
+		return ret
+	}
+	first := gen()
+	second := gen()
+	assert.Equal(t, first, second)
+
+	c.SetSeed(0)
+	assert.Nil(t, s.Rand)
+}
+
+func TestGUIDFromRandgen(t *testing.T) {
+	token := Token{Name: "guid", Type: "random", Replacement: "guid"}
+	n := time.Now()
+	r1, _, _ := token.GenReplacement(-1, n, n, n, rand.New(rand.NewSource(1)), map[string]string{})
+	r2, _, _ := token.GenReplacement(-1, n, n, n, rand.New(rand.NewSource(1)), map[string]string{})
+	assert.Equal(t, r1, r2)
+	assert.Len(t, r1, 36)
+	assert.Equal(t, byte('4'), r1[14])
+}
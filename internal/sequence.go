@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/coccyx/gogen/logger"
+)
+
+// sequenceStateWidth is the fixed width of a sequence state file, so the value can be rewritten in place
+const sequenceStateWidth = 20
+
+// sequenceSaveInterval is how often the last value of a sequence is written to its state file while running
+const sequenceSaveInterval = time.Second
+
+// sequence holds the shared counter for a sequence token.  Tokens are copied by value,
+// so all copies point at the same sequence.
+type sequence struct {
+	mutex sync.Mutex
+	next  int64
+	last  int64
+	dirty bool
+	saved time.Time
+	path  string
+	file  *os.File
+}
+
+// setupSequence validates a sequence token and loads its last value from StateFile if present
+func (t *Token) setupSequence() error {
+	if t.Step < 0 {
+		return fmt.Errorf("step cannot be negative")
+	}
+	setDefault(&t.Step, 1)
+	if t.Wrap != 0 && t.Wrap < t.Start {
+		return fmt.Errorf("wrap cannot be less than start")
+	}
+	seq := &sequence{next: t.Start}
+	if t.StateFile != "" {
+		seq.path = os.ExpandEnv(t.StateFile)
+		contents, err := os.ReadFile(seq.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		} else if err == nil {
+			last, err := strconv.ParseInt(strings.TrimSpace(string(contents)), 10, 64)
+			if err != nil {
+				return fmt.Errorf("cannot parse state file '%s': %s", seq.path, err)
+			}
+			log.Infof("Continuing sequence for token '%s' from %d", t.Name, last)
+			seq.next = t.wrapSequence(last + t.Step)
+		}
+	}
+	t.sequence = seq
+	return nil
+}
+
+// wrapSequence returns Start if v is beyond Wrap
+func (t Token) wrapSequence(v int64) int64 {
+	if t.Wrap != 0 && v > t.Wrap {
+		return t.Start
+	}
+	return v
+}
+
+// nextSequence returns the next value of the sequence, zero padded to Padding.  If StateFile is set, the last
+// value is persisted every sequenceSaveInterval and when the sequence is closed.
+func (t Token) nextSequence() (string, error) {
+	seq := t.sequence
+	seq.mutex.Lock()
+	defer seq.mutex.Unlock()
+	v := seq.next
+	seq.next = t.wrapSequence(v + t.Step)
+	if seq.path != "" {
+		seq.last = v
+		seq.dirty = true
+		if time.Since(seq.saved) >= sequenceSaveInterval {
+			if err := seq.save(); err != nil {
+				return "", err
+			}
+		}
+	}
+	return fmt.Sprintf("%0*d", t.Padding, v), nil
+}
+
+// save writes the last value to the state file.  Must be called with the mutex held.
+func (seq *sequence) save() error {
+	if seq.file == nil {
+		f, err := os.OpenFile(seq.path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		seq.file = f
+	}
+	if _, err := seq.file.WriteAt([]byte(fmt.Sprintf("%-*d\n", sequenceStateWidth, seq.last)), 0); err != nil {
+		return err
+	}
+	seq.dirty = false
+	seq.saved = time.Now()
+	return nil
+}
+
+// close persists the last value if it hasn't been yet, and syncs and closes the state file
+func (seq *sequence) close() error {
+	seq.mutex.Lock()
+	defer seq.mutex.Unlock()
+	if seq.dirty {
+		if err := seq.save(); err != nil {
+			return err
+		}
+	}
+	if seq.file == nil {
+		return nil
+	}
+	err := seq.file.Sync()
+	if cerr := seq.file.Close(); err == nil {
+		err = cerr
+	}
+	seq.file = nil
+	return err
+}
+
+// CloseSequences persists the last value of every sequence token with a state file and closes the files
+func (c *Config) CloseSequences() {
+	for _, s := range c.Samples {
+		for _, t := range s.Tokens {
+			if t.sequence == nil || t.sequence.path == "" {
+				continue
+			}
+			if err := t.sequence.close(); err != nil {
+				log.Errorf("Error persisting sequence for token '%s' in sample '%s': %s", t.Name, s.Name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func genSequence(t *testing.T, token Token, n int) []string {
+	randgen := rand.New(rand.NewSource(0))
+	now := time.Now()
+	ret := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		r, _, err := token.GenReplacement(-1, now, now, now, randgen, map[string]string{})
+		assert.NoError(t, err)
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+func TestSequence(t *testing.T) {
+	token := Token{Name: "seq", Type: "sequence", Start: 8, Step: 2, Padding: 3, Wrap: 12}
+	assert.NoError(t, token.setupSequence())
+	assert.Equal(t, []string{"008", "010", "012", "008", "010"}, genSequence(t, token, 5))
+
+	token = Token{Name: "seq", Type: "sequence"}
+	assert.NoError(t, token.setupSequence())
+	assert.Equal(t, int64(1), token.Step)
+	assert.Equal(t, []string{"0", "1", "2"}, genSequence(t, token, 3))
+
+	assert.Error(t, (&Token{Step: -1}).setupSequence())
+	assert.Error(t, (&Token{Start: 10, Wrap: 5}).setupSequence())
+}
+
+func TestSequenceConcurrent(t *testing.T) {
+	token := Token{Name: "seq", Type: "sequence", Start: 1}
+	assert.NoError(t, token.setupSequence())
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(tok Token) {
+			defer wg.Done()
+			for _, v := range genSequence(t, tok, 250) {
+				mu.Lock()
+				seen[v] = true
+				mu.Unlock()
+			}
+		}(token)
+	}
+	wg.Wait()
+	assert.Len(t, seen, 1000)
+	assert.True(t, seen["1000"])
+}
+
+func TestSequenceStateFile(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "seq.state")
+	token := Token{Name: "seq", Type: "sequence", Start: 100, StateFile: stateFile}
+	assert.NoError(t, token.setupSequence())
+	assert.Equal(t, []string{"100", "101", "102"}, genSequence(t, token, 3))
+	assert.NoError(t, token.sequence.close())
+
+	contents, err := os.ReadFile(stateFile)
+	assert.NoError(t, err)
+	last, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	assert.NoError(t, err)
+	assert.Equal(t, 102, last)
+
+	// A restarted token picks up after the last persisted value
+	token = Token{Name: "seq", Type: "sequence", Start: 100, StateFile: stateFile}
+	assert.NoError(t, token.setupSequence())
+	assert.Equal(t, []string{"103", "104"}, genSequence(t, token, 2))
+	assert.NoError(t, token.sequence.close())
+
+	os.WriteFile(stateFile, []byte("garbage"), 0644)
+	token = Token{Name: "seq", Type: "sequence", StateFile: stateFile}
+	assert.Error(t, token.setupSequence())
+}
+
+func TestSequenceStateFileSaves(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "seq.state")
+	token := Token{Name: "seq", Type: "sequence", Start: 1, StateFile: stateFile}
+	assert.NoError(t, token.setupSequence())
+	readState := func() string {
+		contents, err := os.ReadFile(stateFile)
+		assert.NoError(t, err)
+		return strings.TrimSpace(string(contents))
+	}
+
+	// The first value is saved straight away, and later ones no more than every sequenceSaveInterval
+	genSequence(t, token, 1)
+	assert.Equal(t, "1", readState())
+	token.sequence.saved = time.Now()
+	genSequence(t, token, 99)
+	assert.Equal(t, "1", readState())
+	token.sequence.saved = time.Now().Add(-sequenceSaveInterval)
+	genSequence(t, token, 1)
+	assert.Equal(t, "101", readState())
+	token.sequence.saved = time.Now()
+	genSequence(t, token, 1)
+
+	// Closing the config saves the last value and closes the file
+	c := &Config{Samples: []*Sample{{Name: "seq", Tokens: []Token{token}}}}
+	c.CloseSequences()
+	assert.Equal(t, "102", readState())
+	assert.Nil(t, token.sequence.file)
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coccyx/timeparser"
+)
+
+const hoursPerWeek = 7 * 24
+
+// TimeSeries is a curve of rate multipliers for the timeseries rater, loaded from a CSV.  The first column of
+// the CSV is either a timestamp, or if its header is hourOfWeek, hours since midnight on Sunday, which repeats
+// every week.  The second column is the multiplier.
+type TimeSeries struct {
+	hourOfWeek bool
+	step       bool
+	points     []timeSeriesPoint
+}
+
+// timeSeriesPoint is a multiplier at a time, in epoch seconds or hours of the week
+type timeSeriesPoint struct {
+	t     float64
+	value float64
+}
+
+// LoadTimeSeries reads a time series from the CSV at path.  With step, the multiplier holds until the next
+// point instead of changing linearly between points.
+func LoadTimeSeries(path string, step bool) (*TimeSeries, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading header of '%s': %s", path, err)
+	}
+	if len(header) < 2 {
+		return nil, fmt.Errorf("header of '%s' needs a time and a multiplier column", path)
+	}
+	ts := &TimeSeries{hourOfWeek: strings.EqualFold(strings.TrimSpace(header[0]), "hourOfWeek"), step: step}
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading '%s': %s", path, err)
+		}
+		if len(row) < 2 {
+			return nil, fmt.Errorf("line %d of '%s' needs a time and a multiplier", line, path)
+		}
+		var p timeSeriesPoint
+		if ts.hourOfWeek {
+			if p.t, err = strconv.ParseFloat(row[0], 64); err != nil || p.t < 0 || p.t >= hoursPerWeek {
+				return nil, fmt.Errorf("hour of week '%s' on line %d of '%s' must be a number from 0 to less than 168", row[0], line, path)
+			}
+		} else if p.t, err = parseSeriesTime(row[0]); err != nil {
+			return nil, fmt.Errorf("error parsing time '%s' on line %d of '%s': %s", row[0], line, path, err)
+		}
+		if p.value, err = strconv.ParseFloat(row[1], 64); err != nil {
+			return nil, fmt.Errorf("multiplier '%s' on line %d of '%s' is not a number", row[1], line, path)
+		}
+		ts.points = append(ts.points, p)
+	}
+	if len(ts.points) == 0 {
+		return nil, fmt.Errorf("no points in '%s'", path)
+	}
+	sort.SliceStable(ts.points, func(i, j int) bool {
+		return ts.points[i].t < ts.points[j].t
+	})
+	return ts, nil
+}
+
+// parseSeriesTime parses epoch seconds or a timestamp into epoch seconds
+func parseSeriesTime(s string) (float64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty time")
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		if t, err = timeparser.TimeParser(s); err != nil {
+			return 0, err
+		}
+	}
+	return float64(t.UnixNano()) / float64(time.Second), nil
+}
+
+// Rate returns the multiplier at now.  Timestamp series hold their first and last multipliers before and
+// after the series, and hour of week series wrap around from the end of the week to the start.
+func (ts *TimeSeries) Rate(now time.Time) float64 {
+	var x float64
+	if ts.hourOfWeek {
+		x = float64(int(now.Weekday())*24+now.Hour()) + float64(now.Minute())/60 + float64(now.Second())/3600
+	} else {
+		x = float64(now.UnixNano()) / float64(time.Second)
+	}
+	points := ts.points
+	// Index of the first point after x
+	i := sort.Search(len(points), func(i int) bool {
+		return points[i].t > x
+	})
+	var prev, next timeSeriesPoint
+	switch {
+	case ts.hourOfWeek && (i == 0 || i == len(points)):
+		prev, next = points[len(points)-1], points[0]
+		next.t += hoursPerWeek
+		if i == 0 {
+			x += hoursPerWeek
+		}
+	case i == 0:
+		return points[0].value
+	case i == len(points):
+		return points[len(points)-1].value
+	default:
+		prev, next = points[i-1], points[i]
+	}
+	if ts.step || next.t == prev.t {
+		return prev.value
+	}
+	return prev.value + (next.value-prev.value)*(x-prev.t)/(next.t-prev.t)
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTimeSeries(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "series.csv")
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func TestTimeSeriesLinear(t *testing.T) {
+	ts, err := LoadTimeSeries(writeTimeSeries(t, "time,multiplier\n1000,4\n0,2\n"), false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, ts.Rate(time.Unix(-100, 0)))
+	assert.Equal(t, 2.0, ts.Rate(time.Unix(0, 0)))
+	assert.Equal(t, 3.0, ts.Rate(time.Unix(500, 0)))
+	assert.Equal(t, 4.0, ts.Rate(time.Unix(1000, 0)))
+	assert.Equal(t, 4.0, ts.Rate(time.Unix(2000, 0)))
+}
+
+func TestTimeSeriesStep(t *testing.T) {
+	ts, err := LoadTimeSeries(writeTimeSeries(t, "time,multiplier\n1970-01-01T00:00:00Z,2\n1970-01-01T00:10:00Z,4\n"), true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, ts.Rate(time.Unix(599, 0)))
+	assert.Equal(t, 4.0, ts.Rate(time.Unix(600, 0)))
+}
+
+func TestTimeSeriesHourOfWeek(t *testing.T) {
+	ts, err := LoadTimeSeries(writeTimeSeries(t, "hourOfWeek,multiplier\n12,1\n156,3\n"), false)
+	assert.NoError(t, err)
+	sunday := time.Date(2001, 10, 21, 0, 0, 0, 0, time.Local)
+	assert.Equal(t, 1.0, ts.Rate(sunday.Add(12*time.Hour)))
+	assert.Equal(t, 2.0, ts.Rate(sunday.Add(84*time.Hour)))
+	// Wraps from Saturday noon to Sunday noon
+	assert.Equal(t, 2.0, ts.Rate(sunday))
+	assert.Equal(t, 2.5, ts.Rate(sunday.Add(162*time.Hour)))
+	assert.Equal(t, 1.5, ts.Rate(sunday.Add(6*time.Hour)))
+}
+
+func TestTimeSeriesBadFiles(t *testing.T) {
+	for _, contents := range []string{
+		"",
+		"time\n0\n",
+		"time,multiplier\n",
+		"time,multiplier\nnotatime,1\n",
+		"time,multiplier\n0,notanumber\n",
+		"time,multiplier\n0\n",
+		"hourOfWeek,multiplier\n168,1\n",
+	} {
+		_, err := LoadTimeSeries(writeTimeSeries(t, contents), false)
+		assert.Error(t, err, "contents %q", contents)
+	}
+	_, err := LoadTimeSeries(filepath.Join(t.TempDir(), "notfound.csv"), false)
+	assert.Error(t, err)
+}
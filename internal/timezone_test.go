@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimezone(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join("..", "tests", "timezone", "timezone.yml"))
+	defer os.Unsetenv("GOGEN_FULLCONFIG")
+
+	c := NewConfig()
+	assert.Nil(t, c.FindSampleByName("badtimezone"))
+	s := c.FindSampleByName("tokyo")
+	if !assert.NotNil(t, s) {
+		return
+	}
+
+	randgen := rand.New(rand.NewSource(0))
+	gen := func(n time.Time) string {
+		event := s.Lines[0]["_raw"]
+		for _, tok := range s.Tokens {
+			_, err := tok.Replace(&event, -1, n, n, n, randgen, map[string]string{})
+			assert.NoError(t, err)
+		}
+		return event
+	}
+	winter := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	summer := time.Date(2024, 7, 15, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "2024-01-15 21:00:00 +0900 JST|2024-01-15 21:00:00 +0900 JST|2024-01-15 07:00:00 -0500 EST", gen(winter))
+	assert.Equal(t, "2024-07-15 21:00:00 +0900 JST|2024-07-15 21:00:00 +0900 JST|2024-07-15 08:00:00 -0400 EDT", gen(summer))
+
+	// Timestamps without a zone are parsed in the token's timezone
+	tok := Token{Type: "gotimestamp", Replacement: "2006-01-02 15:04:05"}
+	assert.NoError(t, tok.setupTimezone("America/New_York"))
+	ts, err := tok.ParseTimestamp("2024-07-15 08:00:00")
+	assert.NoError(t, err)
+	assert.True(t, summer.Equal(ts))
+	tok = Token{Type: "timestamp", Replacement: "%Y-%m-%d %H:%M:%S", Timezone: "Asia/Tokyo"}
+	assert.NoError(t, tok.setupTimezone("America/New_York"))
+	ts, err = tok.ParseTimestamp("2024-01-15 21:00:00")
+	assert.NoError(t, err)
+	assert.True(t, winter.Equal(ts))
+}
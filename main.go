@@ -190,6 +190,47 @@ func table(l []config.GogenList) {
 	t.Render()
 }
 
+// learn prints a rater learned from the log file given as the first argument
+func learn(clic *cli.Context) {
+	s := c.FindSampleByName(clic.String("sample"))
+	if s == nil {
+		log.Fatalf("Sample '%s' not found", clic.String("sample"))
+	}
+	var token *config.Token
+	for i, t := range s.Tokens {
+		if (t.Type == "timestamp" || t.Type == "gotimestamp" || t.Type == "epochtimestamp") &&
+			(clic.String("token") == "" || t.Name == clic.String("token")) {
+			token = &s.Tokens[i]
+			break
+		}
+	}
+	if token == nil {
+		log.Fatalf("No timestamp token found in sample '%s'", s.Name)
+	}
+	l, err := config.LearnRates(clic.Args().First(), *token)
+	if err != nil {
+		log.WithError(err).Fatalf("Error learning rates")
+	}
+	r := l.ConfigRater(clic.String("name"))
+	if file := clic.String("timeseries"); file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			log.WithError(err).Fatalf("Error creating time series file")
+		}
+		if r, err = l.TimeSeriesRater(clic.String("name"), file, f); err == nil {
+			err = f.Close()
+		}
+		if err != nil {
+			log.WithError(err).Fatalf("Error writing time series file")
+		}
+	}
+	out, err := yaml.Marshal(map[string][]*config.RaterConfig{"raters": {r}})
+	if err != nil {
+		log.Panicf("YAML output error: %v", err)
+	}
+	fmt.Print(string(out))
+}
+
 func main() {
 	defer func() {
 		os.Remove(filepath.Join(os.ExpandEnv("$GOGEN_TMPDIR"), ".config.yml"))
@@ -249,6 +290,10 @@ func main() {
 					Name:  "wait, w",
 					Usage: "Wait between intervals when backfilling",
 				},
+				cli.Int64Flag{
+					Name:  "seed",
+					Usage: "Seed all random generation with `seed` for reproducible output",
+				},
 			},
 			Action: func(clic *cli.Context) error {
 				if len(c.Samples) == 0 {
@@ -299,6 +344,10 @@ func main() {
 						c.Samples[i].Wait = true
 					}
 				}
+				if clic.IsSet("seed") {
+					log.Infof("Setting seed to %d", clic.Int64("seed"))
+					c.SetSeed(clic.Int64("seed"))
+				}
 				samplesSlice := clic.StringSlice("sample")
 				samplesStr := strings.Join(samplesSlice, " ")
 				samplesMap := make(map[string]bool, len(samplesSlice))
@@ -357,6 +406,44 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:  "learn",
+			Usage: "Learn a rater from the volume of an existing log file",
+			ArgsUsage: "[logfile]\n\n" + "This reads the timestamps of every line of the log file with a timestamp token from a sample in the config,\n" +
+				"and prints a config rater with HourOfDay, DayOfWeek and MinuteOfHour multipliers normalized to a mean of 1.\n" +
+				"With --timeseries, it instead writes an hour of week time series to the file and prints a timeseries rater.",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "sample, s",
+					Usage: "Use the timestamp token from sample `name` (required)",
+				},
+				cli.StringFlag{
+					Name:  "token, t",
+					Usage: "Use the timestamp token `name`, default the first timestamp token in the sample",
+				},
+				cli.StringFlag{
+					Name:  "name, n",
+					Value: "learned",
+					Usage: "Set the `name` of the rater",
+				},
+				cli.StringFlag{
+					Name:  "timeseries, ts",
+					Usage: "Write an hour of week time series to `file` for a timeseries rater",
+				},
+			},
+			Action: func(clic *cli.Context) error {
+				if len(clic.Args()) == 0 {
+					fmt.Println("Error: Must specify a log file to learn from")
+					os.Exit(1)
+				}
+				if clic.String("sample") == "" {
+					fmt.Println("Error: Must specify a sample with --sample")
+					cli.ShowCommandHelpAndExit(clic, "learn", 1)
+				}
+				learn(clic)
+				return nil
+			},
+		},
 		{
 			Name:  "login",
 			Usage: "Login to GitHub",
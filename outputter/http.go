@@ -2,6 +2,7 @@ package outputter
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -11,12 +12,16 @@ import (
 	config "github.com/coccyx/gogen/internal"
 )
 
+// maxHTTPFlushFailures is how many flushes in a row can fail before the buffer is dropped, so an endpoint
+// which is down doesn't grow the buffer without bound
+const maxHTTPFlushFailures = 3
+
 type httpout struct {
 	buf            *bytes.Buffer
+	failures       int
 	client         *http.Client
 	resp           *http.Response
 	initialized    bool
-	closed         bool
 	endpoint       string
 	headers        map[string]string
 	lastSampleName string
@@ -37,17 +42,42 @@ func (h *httpout) Send(item *config.OutQueueItem) error {
 		return err
 	}
 
-	if h.buf.Len() > item.S.Output.BufferBytes {
+	// Once the run is cancelled, leave the buffer for Close to flush
+	if h.buf.Len() > item.S.Output.BufferBytes && (item.Ctx == nil || item.Ctx.Err() == nil) {
 		h.endpoint = item.S.Output.Endpoints[rand.Intn(len(item.S.Output.Endpoints))]
 		h.headers = item.S.Output.Headers
 		h.lastSampleName = item.S.Name
-		return h.flush()
+		return h.flush(item.Ctx)
 	}
 	return nil
 }
 
-func (h *httpout) flush() error {
-	req, err := http.NewRequest("POST", h.endpoint, h.buf)
+// flush posts the buffer, which is only reset once the endpoint accepts it, so a failed or
+// cancelled flush is retried by the next one.  After maxHTTPFlushFailures in a row, the buffer is dropped.
+func (h *httpout) flush(ctx context.Context) error {
+	err := h.post(ctx)
+	if err == nil {
+		h.failures = 0
+		h.buf.Reset()
+		return nil
+	}
+	h.failures++
+	if h.failures >= maxHTTPFlushFailures {
+		err = fmt.Errorf("%s, dropping %d bytes after %d failed flushes", err, h.buf.Len(), h.failures)
+		h.failures = 0
+		h.buf.Reset()
+	}
+	return err
+}
+
+func (h *httpout) post(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", h.endpoint, bytes.NewReader(h.buf.Bytes()))
+	if err != nil {
+		return fmt.Errorf("Error making request from sample '%s' to endpoint '%s': %s", h.lastSampleName, h.endpoint, err)
+	}
 	for k, v := range h.headers {
 		req.Header.Add(k, v)
 	}
@@ -62,17 +92,14 @@ func (h *httpout) flush() error {
 	} else if h.resp.StatusCode < 200 || h.resp.StatusCode > 299 {
 		return fmt.Errorf("Error making request from sample '%s' to endpoint '%s', status '%d': %s", h.lastSampleName, h.endpoint, h.resp.StatusCode, body)
 	}
-	h.buf.Reset()
 	return nil
 }
 
+// Close flushes anything left in the buffer.  It isn't tied to the run's context, so the final batch
+// is still sent after cancellation, bounded by the output timeout.
 func (h *httpout) Close() error {
-	if !h.closed {
-		h.closed = true
-		err := h.flush()
-		if err != nil {
-			return err
-		}
+	if !h.initialized || h.buf.Len() == 0 {
+		return nil
 	}
-	return nil
+	return h.flush(context.Background())
 }
@@ -2,6 +2,7 @@ package outputter
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -18,9 +19,8 @@ var (
 	EventsWritten map[string]int64
 	BytesWritten  map[string]int64
 	Mutex         sync.RWMutex
-	lastTS        time.Time
 	rotchan       chan *config.OutputStats
-	rotOnce       sync.Once
+	rotMutex      sync.Mutex
 	rotwg         sync.WaitGroup
 	gout          [config.MaxOutputThreads]config.Outputter
 	lasterr       [config.MaxOutputThreads]lastError
@@ -45,24 +45,29 @@ func init() {
 // multiple times; initialization only happens once until ReadFinal resets it.
 // Called automatically by ROT, but can be called separately for testing.
 func InitROT(c *config.Config) {
-	rotOnce.Do(func() {
+	rotMutex.Lock()
+	defer rotMutex.Unlock()
+	if rotchan == nil {
 		rotInterval = c.Global.ROTInterval
 		rotchan = make(chan *config.OutputStats)
 		rotwg.Add(1)
 		go readStats()
-	})
+	}
 }
 
 // ROT starts the Read Out Thread which will log statistics about what's being output
 // ROT is intended to be started as a goroutine which will log output every c.
 func ROT(c *config.Config) {
 	InitROT(c)
+	// ROTs from earlier runs may still be logging, so use this run's interval rather than the shared one
+	rotInterval := c.Global.ROTInterval
 
 	lastEventsWritten := make(map[string]int64)
 	lastBytesWritten := make(map[string]int64)
 	var gbday, eventssec, kbytessec float64
 	var tempEW, tempBW int64
-	lastTS = time.Now()
+	// Each ROT keeps its own last readout time, since ROTs from earlier runs may still be logging
+	lastTS := time.Now()
 	for {
 		timer := time.NewTimer(time.Duration(rotInterval) * time.Second)
 		<-timer.C
@@ -91,10 +96,12 @@ func ROT(c *config.Config) {
 
 // ReadFinal outputs final statistics about our run
 func ReadFinal() {
+	rotMutex.Lock()
 	close(rotchan)
 	rotwg.Wait()
 	// Reset so ROT can be re-initialized (needed for tests)
-	rotOnce = sync.Once{}
+	rotchan = nil
+	rotMutex.Unlock()
 
 	totalEvents := int64(0)
 	totalBytes := int64(0)
@@ -126,10 +133,16 @@ func Account(eventsWritten int64, bytesWritten int64, sampleName string) {
 	os.EventsWritten = eventsWritten
 	os.BytesWritten = bytesWritten
 	os.SampleName = sampleName
-	for rotchan == nil {
+	for {
+		rotMutex.Lock()
+		ch := rotchan
+		rotMutex.Unlock()
+		if ch != nil {
+			ch <- os
+			return
+		}
 		time.Sleep(10 * time.Millisecond)
 	}
-	rotchan <- os
 }
 
 func write(item *config.OutQueueItem) {
@@ -154,100 +167,110 @@ func write(item *config.OutQueueItem) {
 	defer item.IO.W.Close()
 	if !useCache {
 		item.Cache.RLock()
-		switch item.S.Output.OutputTemplate {
-		case "raw", "json", "splunkhec", "rfc3164", "rfc5424", "elasticsearch":
-			for _, line := range item.Events {
-				var tempbytes int
-				var err error
-				if item.S.Output.Outputter != "devnull" {
-					switch item.S.Output.OutputTemplate {
-					case "raw":
-						tempbytes, err = io.WriteString(w, line["_raw"])
-					case "json":
-						jb, err := json.Marshal(line)
-						if err != nil {
-							log.Errorf("Error marshaling json: %s", err)
-						}
-						tempbytes, err = w.Write(jb)
-					case "splunkhec":
-						template.TransformHECFields(line)
-						jb, err := json.Marshal(line)
-						if err != nil {
-							log.Errorf("Error marshaling json: %s", err)
-						}
-						tempbytes, err = w.Write(jb)
-					case "rfc3164":
-						tempbytes, err = io.WriteString(w, fmt.Sprintf("<%s>%s %s %s[%s]: %s", line["priority"], line["_time"], line["host"], line["tag"], line["pid"], line["_raw"]))
-					case "rfc5424":
-						kv := "-"
-						for k, v := range line {
-							if k != "_raw" && k != "_time" && k != "priority" && k != "host" && k != "appName" && k != "pid" && k != "tag" {
-								kv = kv + fmt.Sprintf("%s=\"%s\" ", k, v)
-							}
-						}
-						if len(kv) != 1 {
-							kv = fmt.Sprintf("[meta %s]", kv[1:len(kv)-1])
-						}
-						tempbytes, err = io.WriteString(w, fmt.Sprintf("<%s>%d %s %s %s %s - %s %s", line["priority"], 1, line["_time"], line["host"], line["appName"], line["pid"], kv, line["_raw"]))
-					case "elasticsearch":
-						_, err := io.WriteString(w, fmt.Sprintf("{ \"index\": { \"_index\": \"%s\", \"_type\": \"doc\" } }\n", line["index"]))
-						if err != nil {
-							break
-						}
-						if _, ok := line["_raw"]; ok {
-							line["message"] = line["_raw"]
-							delete(line, "_raw")
-						}
-						jb, err := json.Marshal(line)
-						if err != nil {
-							break
+		bytesCounter = Render(item, w)
+		item.Cache.RUnlock()
+	}
+	if useCache || item.Cache.SetCache {
+		tempBytes, err := item.IO.W.Write(cacheBufs[item.S.Name].Bytes())
+		if err != nil {
+			log.Errorf("Error reading from cache buffer: %s", err)
+		}
+		bytesCounter = int64(tempBytes)
+		// log.Infof("Used cache, sent %d events and %d bytes", len(item.Events), bytesCounter)
+	}
+	Account(int64(len(item.Events)), bytesCounter, item.S.Name)
+}
+
+// Render writes the events of item to w formatted by the sample's output template, returning the number of bytes written
+func Render(item *config.OutQueueItem, w io.Writer) (bytesCounter int64) {
+	switch item.S.Output.OutputTemplate {
+	case "raw", "json", "splunkhec", "rfc3164", "rfc5424", "elasticsearch":
+		for _, line := range item.Events {
+			var tempbytes int
+			var err error
+			if item.S.Output.Outputter != "devnull" {
+				switch item.S.Output.OutputTemplate {
+				case "raw":
+					tempbytes, err = io.WriteString(w, line["_raw"])
+				case "json":
+					jb, err := json.Marshal(line)
+					if err != nil {
+						log.Errorf("Error marshaling json: %s", err)
+					}
+					tempbytes, err = w.Write(jb)
+				case "splunkhec":
+					template.TransformHECFields(line)
+					jb, err := json.Marshal(line)
+					if err != nil {
+						log.Errorf("Error marshaling json: %s", err)
+					}
+					tempbytes, err = w.Write(jb)
+				case "rfc3164":
+					tempbytes, err = io.WriteString(w, fmt.Sprintf("<%s>%s %s %s[%s]: %s", line["priority"], line["_time"], line["host"], line["tag"], line["pid"], line["_raw"]))
+				case "rfc5424":
+					kv := "-"
+					for k, v := range line {
+						if k != "_raw" && k != "_time" && k != "priority" && k != "host" && k != "appName" && k != "pid" && k != "tag" {
+							kv = kv + fmt.Sprintf("%s=\"%s\" ", k, v)
 						}
-						tempbytes, err = w.Write(jb)
 					}
+					if len(kv) != 1 {
+						kv = fmt.Sprintf("[meta %s]", kv[1:len(kv)-1])
+					}
+					tempbytes, err = io.WriteString(w, fmt.Sprintf("<%s>%d %s %s %s %s - %s %s", line["priority"], 1, line["_time"], line["host"], line["appName"], line["pid"], kv, line["_raw"]))
+				case "elasticsearch":
+					_, err := io.WriteString(w, fmt.Sprintf("{ \"index\": { \"_index\": \"%s\", \"_type\": \"doc\" } }\n", line["index"]))
 					if err != nil {
-						log.Errorf("Error writing to IO Buffer: %s", err)
+						break
 					}
-				} else {
-					tempbytes = len(line["_raw"])
-				}
-				bytesCounter += int64(tempbytes) + 1
-				if item.S.Output.Outputter != "devnull" && item.S.Output.Outputter != "kafka" {
-					_, err = io.WriteString(w, "\n")
+					if _, ok := line["_raw"]; ok {
+						line["message"] = line["_raw"]
+						delete(line, "_raw")
+					}
+					jb, err := json.Marshal(line)
 					if err != nil {
-						log.Errorf("Error writing to IO Buffer: %s", err)
+						break
 					}
+					tempbytes, err = w.Write(jb)
 				}
+				if err != nil {
+					log.Errorf("Error writing to IO Buffer: %s", err)
+				}
+			} else {
+				tempbytes = len(line["_raw"])
 			}
-		default:
-			if !template.Exists(item.S.Output.OutputTemplate + "_row") {
-				log.Errorf("Template %s does not exist, skipping output", item.S.Output.OutputTemplate)
-				return
-			}
-			// We'll crash on empty events, but don't do that!
-			bytesCounter += int64(getLine("header", item.S, item.Events[0], w))
-			// log.Debugf("Out Queue Item %#v", item)
-			var last int
-			for i, line := range item.Events {
-				bytesCounter += int64(getLine("row", item.S, line, w))
-				last = i
+			bytesCounter += int64(tempbytes) + 1
+			if item.S.Output.Outputter != "devnull" && item.S.Output.Outputter != "kafka" {
+				_, err = io.WriteString(w, "\n")
+				if err != nil {
+					log.Errorf("Error writing to IO Buffer: %s", err)
+				}
 			}
-			bytesCounter += int64(getLine("footer", item.S, item.Events[last], w))
 		}
-		item.Cache.RUnlock()
-	}
-	if useCache || item.Cache.SetCache {
-		tempBytes, err := item.IO.W.Write(cacheBufs[item.S.Name].Bytes())
-		if err != nil {
-			log.Errorf("Error reading from cache buffer: %s", err)
+	default:
+		if !template.Exists(item.S.Output.OutputTemplate + "_row") {
+			log.Errorf("Template %s does not exist, skipping output", item.S.Output.OutputTemplate)
+			return 0
 		}
-		bytesCounter = int64(tempBytes)
-		// log.Infof("Used cache, sent %d events and %d bytes", len(item.Events), bytesCounter)
+		if len(item.Events) == 0 {
+			return 0
+		}
+		bytesCounter += int64(getLine("header", item.S, item.Events[0], w))
+		// log.Debugf("Out Queue Item %#v", item)
+		var last int
+		for i, line := range item.Events {
+			bytesCounter += int64(getLine("row", item.S, line, w))
+			last = i
+		}
+		bytesCounter += int64(getLine("footer", item.S, item.Events[last], w))
 	}
-	Account(int64(len(item.Events)), bytesCounter, item.S.Name)
+	return bytesCounter
 }
 
-// Start starts an output thread and runs until notified to shut down
-func Start(oq chan *config.OutQueueItem, oqs chan int, num int) {
+// Start starts an output thread and runs until oq is closed, then closes its outputter, flushing anything
+// buffered, and signals oqs.  Every item queued is still sent once ctx is cancelled, but outputs can use
+// the item's Ctx to give up early on slow sends.
+func Start(ctx context.Context, oq chan *config.OutQueueItem, oqs chan int, num int) {
 	source := rand.NewSource(time.Now().UnixNano())
 	generator := rand.New(source)
 
@@ -267,6 +290,9 @@ func Start(oq chan *config.OutQueueItem, oqs chan int, num int) {
 			oqs <- 1
 			break
 		}
+		if item.Ctx == nil {
+			item.Ctx = ctx
+		}
 		out = setup(generator, item, num)
 		if len(item.Events) > 0 {
 			go write(item)
@@ -315,7 +341,9 @@ func getLine(templatename string, s *config.Sample, line map[string]string, w io
 }
 
 func setup(generator *rand.Rand, item *config.OutQueueItem, num int) config.Outputter {
-	item.Rand = generator
+	if item.Rand == nil {
+		item.Rand = generator
+	}
 	item.IO = config.NewOutputIO()
 
 	if gout[num] == nil {
@@ -336,7 +364,11 @@ func setup(generator *rand.Rand, item *config.OutQueueItem, num int) config.Outp
 		case "kafka":
 			gout[num] = new(kafkaout)
 		default:
-			gout[num] = new(stdout)
+			if factory := config.FindOutputterFactory(item.S.Output.Outputter); factory != nil {
+				gout[num] = registered{factory()}
+			} else {
+				gout[num] = new(stdout)
+			}
 		}
 	}
 	return gout[num]
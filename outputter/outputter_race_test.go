@@ -9,7 +9,9 @@ import (
 )
 
 func TestAccountWaitsForROTInitialization(t *testing.T) {
+	rotMutex.Lock()
 	rotchan = nil
+	rotMutex.Unlock()
 	rotwg = sync.WaitGroup{}
 
 	done := make(chan struct{})
@@ -24,18 +26,8 @@ func TestAccountWaitsForROTInitialization(t *testing.T) {
 	case <-time.After(20 * time.Millisecond):
 	}
 
-	rotInterval = 1
-	rotwg.Add(1)
-	rotchan = make(chan *config.OutputStats)
-	go readStats()
-
-	t.Cleanup(func() {
-		if rotchan != nil {
-			close(rotchan)
-			rotwg.Wait()
-			rotchan = nil
-		}
-	})
+	InitROT(&config.Config{Global: config.Global{ROTInterval: 1}})
+	t.Cleanup(ReadFinal)
 
 	select {
 	case <-done:
@@ -30,7 +30,9 @@ func TestReadFinalSynchronization(t *testing.T) {
 	// Note: This assumes ROT checks for nil or always makes a new channel. If ROT
 	// has complex logic around existing channels, this might need adjustment.
 	// Closing the old channel here could panic if already closed. Setting to nil is safer.
+	rotMutex.Lock()
 	rotchan = nil
+	rotMutex.Unlock()
 	Mutex.Unlock()
 
 	// Create a minimal configuration required by ROT
@@ -61,7 +63,7 @@ func TestReadFinalSynchronization(t *testing.T) {
 	}
 
 	// Send a single stat message
-	rotchan <- statToSend
+	Account(statToSend.EventsWritten, statToSend.BytesWritten, statToSend.SampleName)
 
 	// --- Trigger Potential Race ---
 	// Immediately call ReadFinal.
@@ -0,0 +1,48 @@
+package outputter
+
+import (
+	"io"
+
+	config "github.com/coccyx/gogen/internal"
+)
+
+// OutQueueItem is one batch of events for an Outputter to send
+type OutQueueItem = config.OutQueueItem
+
+// Outputter sends batches of events somewhere
+type Outputter = config.Outputter
+
+// Factory creates an Outputter.  Each output worker calls it once, when it receives its first item.
+type Factory = config.OutputterFactory
+
+var builtinOutputters = map[string]bool{
+	"stdout":  true,
+	"devnull": true,
+	"file":    true,
+	"http":    true,
+	"buf":     true,
+	"network": true,
+	"kafka":   true,
+}
+
+// RegisterOutputter makes a native Go outputter available as `outputter: name`.  Send receives the raw
+// events in item.Events and their rendering by the output template in item.IO.R, which it may ignore.
+// It panics if name is a builtin or already registered, so it is intended to be called from init.
+func RegisterOutputter(name string, factory Factory) {
+	if builtinOutputters[name] {
+		panic("gogen: RegisterOutputter cannot replace builtin outputter " + name)
+	}
+	config.RegisterOutputter(name, factory)
+}
+
+// registered wraps a registered Outputter, draining whatever it didn't read of the rendered output
+// so the template writer isn't left blocked on the pipe
+type registered struct {
+	config.Outputter
+}
+
+func (r registered) Send(item *config.OutQueueItem) error {
+	err := r.Outputter.Send(item)
+	_, _ = io.Copy(io.Discard, item.IO.R)
+	return err
+}
@@ -2,6 +2,7 @@ package outputter
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"math/rand"
 	"net"
@@ -369,7 +370,7 @@ func TestStartDevnullWorker(t *testing.T) {
 	oq := make(chan *config.OutQueueItem)
 	oqs := make(chan int)
 
-	go Start(oq, oqs, 0)
+	go Start(context.Background(), oq, oqs, 0)
 
 	// Send an item through the pipeline
 	s := &config.Sample{
@@ -411,7 +412,7 @@ func TestStartMultipleItems(t *testing.T) {
 	oq := make(chan *config.OutQueueItem)
 	oqs := make(chan int)
 
-	go Start(oq, oqs, 0)
+	go Start(context.Background(), oq, oqs, 0)
 
 	s := &config.Sample{
 		Name: "multistart",
@@ -457,7 +458,7 @@ func TestStartEmptyEvents(t *testing.T) {
 	oq := make(chan *config.OutQueueItem)
 	oqs := make(chan int)
 
-	go Start(oq, oqs, 0)
+	go Start(context.Background(), oq, oqs, 0)
 
 	s := &config.Sample{
 		Name: "emptyevents",
@@ -491,7 +492,7 @@ func TestStartCloseOnChannelClose(t *testing.T) {
 	oq := make(chan *config.OutQueueItem)
 	oqs := make(chan int)
 
-	go Start(oq, oqs, 0)
+	go Start(context.Background(), oq, oqs, 0)
 
 	s := &config.Sample{
 		Name: "closetest",
@@ -533,7 +534,7 @@ func TestStartSendError(t *testing.T) {
 	oq := make(chan *config.OutQueueItem)
 	oqs := make(chan int)
 
-	go Start(oq, oqs, 0)
+	go Start(context.Background(), oq, oqs, 0)
 
 	s := &config.Sample{
 		Name: "senderror",
@@ -594,6 +595,49 @@ func TestHTTPFlushNon200(t *testing.T) {
 	assert.Contains(t, err.Error(), "500")
 }
 
+func TestHTTPFlushDropsAfterFailures(t *testing.T) {
+	var requests []int
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, len(body))
+		mu.Unlock()
+		w.WriteHeader(503)
+	}))
+	defer ts.Close()
+
+	s := &config.Sample{
+		Name: "httpdown",
+		Output: &config.Output{
+			Endpoints:   []string{ts.URL},
+			BufferBytes: 10,
+			Headers:     map[string]string{},
+			Timeout:     5 * time.Second,
+		},
+	}
+
+	h := &httpout{}
+	for i := 0; i < maxHTTPFlushFailures+1; i++ {
+		oio := config.NewOutputIO()
+		item := &config.OutQueueItem{S: s, IO: oio}
+		go func() {
+			io.WriteString(oio.W, strings.Repeat("Z", 20))
+			oio.W.Close()
+		}()
+		err := h.Send(item)
+		assert.Error(t, err)
+		if i == maxHTTPFlushFailures-1 {
+			assert.Contains(t, err.Error(), "dropping 60 bytes")
+			assert.Equal(t, 0, h.buf.Len())
+		}
+	}
+	// Failed flushes are retried with the events added since, until the buffer is dropped
+	mu.Lock()
+	assert.Equal(t, []int{20, 40, 60, 20}, requests)
+	mu.Unlock()
+}
+
 func TestHTTPCloseFlushError(t *testing.T) {
 	// Use a server that accepts the first request (Send flush) but returns error on the second (Close flush)
 	calls := 0
@@ -640,6 +684,51 @@ func TestHTTPCloseFlushError(t *testing.T) {
 	assert.Contains(t, err.Error(), "500")
 }
 
+func TestHTTPCloseAfterCancel(t *testing.T) {
+	var received bytes.Buffer
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		io.Copy(&received, r.Body)
+		mu.Unlock()
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	s := &config.Sample{
+		Name: "httpcancel",
+		Output: &config.Output{
+			Endpoints:   []string{ts.URL},
+			BufferBytes: 10,
+			Headers:     map[string]string{},
+			Timeout:     5 * time.Second,
+		},
+	}
+
+	h := &httpout{}
+
+	// Sends after cancellation don't flush, leaving the buffer for Close
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	oio := config.NewOutputIO()
+	item := &config.OutQueueItem{S: s, IO: oio, Ctx: ctx}
+	go func() {
+		io.WriteString(oio.W, strings.Repeat("Y", 50))
+		oio.W.Close()
+	}()
+	assert.NoError(t, h.Send(item))
+	mu.Lock()
+	assert.Equal(t, 0, received.Len())
+	mu.Unlock()
+
+	// Close still sends the last batch
+	assert.NoError(t, h.Close())
+	mu.Lock()
+	assert.Equal(t, strings.Repeat("Y", 50), received.String())
+	mu.Unlock()
+	assert.Equal(t, 0, h.buf.Len())
+}
+
 func TestStartSendErrorRepeat(t *testing.T) {
 	cleanup := initROT()
 	defer cleanup()
@@ -649,7 +738,7 @@ func TestStartSendErrorRepeat(t *testing.T) {
 	oq := make(chan *config.OutQueueItem)
 	oqs := make(chan int)
 
-	go Start(oq, oqs, 0)
+	go Start(context.Background(), oq, oqs, 0)
 
 	s := &config.Sample{
 		Name: "senderrorrepeat",
@@ -449,3 +449,17 @@ func TestWriteCustomTemplate(t *testing.T) {
 	assert.Contains(t, result, "ROW:custom line")
 	assert.Contains(t, result, "FOOTER")
 }
+
+func TestRenderEmpty(t *testing.T) {
+	cleanup := initROT()
+	defer cleanup()
+
+	_ = template.New("emptytest_header", "HEADER\n")
+	_ = template.New("emptytest_row", "ROW:{{._raw}}\n")
+	_ = template.New("emptytest_footer", "FOOTER\n")
+
+	item := makeOutQueueItem("emptysample", "emptytest", "stdout", []map[string]string{})
+	var buf bytes.Buffer
+	assert.Equal(t, int64(0), Render(item, &buf))
+	assert.Equal(t, "", buf.String())
+}
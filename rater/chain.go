@@ -0,0 +1,69 @@
+package rater
+
+import (
+	"context"
+	"time"
+
+	config "github.com/coccyx/gogen/internal"
+)
+
+// ChainRater combines the rates of several raters, multiplying them together or adding them up
+type ChainRater struct {
+	c      *config.RaterConfig
+	raters []config.Rater
+	add    bool
+}
+
+// newChainRater builds the raters in the chain.  seen holds the chains being built, so a chain which
+// includes itself gets a default rater in its place instead of recursing forever.
+func newChainRater(findRater func(name string) *config.RaterConfig, r *config.RaterConfig, seen map[string]bool) *ChainRater {
+	cr := &ChainRater{c: r, add: r.Options["operation"] == "add"}
+	names, _ := r.Options["raters"].([]string)
+	seen[r.Name] = true
+	for _, name := range names {
+		cr.raters = append(cr.raters, newRater(findRater, name, seen))
+	}
+	delete(seen, r.Name)
+	return cr
+}
+
+// combine folds the rates of the raters in the chain together.  An empty chain returns 1.
+func (cr *ChainRater) combine(rate func(r config.Rater) float64) float64 {
+	if len(cr.raters) == 0 {
+		return 1.0
+	}
+	ret := 1.0
+	if cr.add {
+		ret = 0.0
+	}
+	for _, r := range cr.raters {
+		if cr.add {
+			ret += rate(r)
+		} else {
+			ret *= rate(r)
+		}
+	}
+	return ret
+}
+
+// EventRate takes a given sample and current count and returns the rated count
+func (cr *ChainRater) EventRate(s *config.Sample, now time.Time, count int) float64 {
+	return cr.EventRateContext(context.Background(), s, now, count)
+}
+
+// EventRateContext passes ctx on to raters in the chain which wait
+func (cr *ChainRater) EventRateContext(ctx context.Context, s *config.Sample, now time.Time, count int) float64 {
+	return cr.combine(func(r config.Rater) float64 {
+		if ctxr, ok := r.(ContextRater); ok {
+			return ctxr.EventRateContext(ctx, s, now, count)
+		}
+		return r.EventRate(s, now, count)
+	})
+}
+
+// TokenRate takes a token and returns the rated value
+func (cr *ChainRater) TokenRate(t config.Token, now time.Time) float64 {
+	return cr.combine(func(r config.Rater) float64 {
+		return r.TokenRate(t, now)
+	})
+}
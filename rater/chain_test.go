@@ -0,0 +1,51 @@
+package rater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	config "github.com/coccyx/gogen/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainRater(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join("..", "tests", "rater", "chainrater.yml"))
+
+	c := config.NewConfig()
+	s := c.FindSampleByName("chainrater")
+	midnight := time.Date(2001, 10, 20, 0, 0, 0, 0, time.Local)
+	noon := time.Date(2001, 10, 20, 12, 0, 0, 0, time.Local)
+	randSource = 2
+	assert.Equal(t, 30, EventRate(s, midnight, 10))
+	assert.Equal(t, 8, EventRate(s, noon, 10))
+
+	token := config.Token{Name: "test"}
+	sum := GetSampleRater(s, "sum")
+	assert.IsType(t, &ChainRater{}, sum)
+	assert.Equal(t, 3.5, sum.TokenRate(token, midnight))
+	assert.Equal(t, 2.0, sum.TokenRate(token, noon))
+
+	nested := GetSampleRater(s, "nested")
+	assert.Equal(t, 3.0*3.5, nested.TokenRate(token, midnight))
+
+	// A chain including itself uses the default rater in its place
+	loop := GetSampleRater(s, "loop")
+	assert.Equal(t, 1.5, loop.TokenRate(token, midnight))
+
+	// An invalid chain doesn't change rates
+	bad := GetSampleRater(s, "badoperation")
+	assert.Equal(t, 1.0, bad.TokenRate(token, midnight))
+}
+
+func TestChainRaterContext(t *testing.T) {
+	cr := &ChainRater{
+		c:      &config.RaterConfig{Name: "chain"},
+		raters: []config.Rater{&KBpsRater{c: &config.RaterConfig{Name: "kbps", Options: map[string]interface{}{}}}, &negativeRater{}},
+	}
+	s := &config.Sample{Name: "test"}
+	assert.Equal(t, -1.0, cr.EventRate(s, time.Now(), 10))
+}
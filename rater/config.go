@@ -13,7 +13,10 @@ type ConfigRater struct {
 
 // getRate acts as a general method for EventRate and TokenRate
 func (cr *ConfigRater) getRate(now time.Time) float64 {
-	rate := 1.0
+	rate, override := cr.c.CalendarRate(now)
+	if override {
+		return rate
+	}
 
 	if _, ok := cr.c.Options["HourOfDay"]; ok {
 		hod := cr.c.Options["HourOfDay"].(map[int]float64)
@@ -33,7 +36,18 @@ func (cr *ConfigRater) getRate(now time.Time) float64 {
 			rate *= moh[now.Minute()]
 		}
 	}
-
+	if _, ok := cr.c.Options["DayOfMonth"]; ok {
+		dom := cr.c.Options["DayOfMonth"].(map[int]float64)
+		if _, ok := dom[now.Day()]; ok {
+			rate *= dom[now.Day()]
+		}
+	}
+	if _, ok := cr.c.Options["MonthOfYear"]; ok {
+		moy := cr.c.Options["MonthOfYear"].(map[int]float64)
+		if _, ok := moy[int(now.Month())]; ok {
+			rate *= moy[int(now.Month())]
+		}
+	}
 	return rate
 }
 
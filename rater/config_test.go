@@ -46,3 +46,52 @@ func TestConfigRaterEventRate(t *testing.T) {
 	ret = EventRate(s, now(), 1)
 	assert.Equal(t, 5, ret)
 }
+
+func TestConfigRaterCalendar(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join("..", "tests", "rater", "calendarrater.yml"))
+
+	c := config.NewConfig()
+	cr := &ConfigRater{c: c.FindRater("calendar")}
+	token := config.Token{Name: "test"}
+	day := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.Local)
+	}
+
+	assert.Equal(t, 1.0, cr.TokenRate(token, day(2001, 10, 20)))
+	// DayOfMonth and MonthOfYear
+	assert.Equal(t, 2.0, cr.TokenRate(token, day(2001, 10, 1)))
+	assert.Equal(t, 1.5, cr.TokenRate(token, day(2001, 12, 2)))
+	// Yearly holiday
+	assert.InDelta(t, 0.15, cr.TokenRate(token, day(2001, 12, 25)), 0.0001)
+	assert.InDelta(t, 0.15, cr.TokenRate(token, day(2002, 12, 25)), 0.0001)
+	// Holiday in one year only
+	assert.Equal(t, 0.2, cr.TokenRate(token, day(2001, 11, 22)))
+	assert.Equal(t, 1.0, cr.TokenRate(token, day(2002, 11, 22)))
+	// Date range, including its last day.  The range overrides DayOfMonth, MonthOfYear and holidays
+	assert.Equal(t, 3.0, cr.TokenRate(token, day(2001, 11, 23)))
+	assert.Equal(t, 3.0, cr.TokenRate(token, time.Date(2001, 11, 26, 23, 59, 0, 0, time.Local)))
+	assert.Equal(t, 1.0, cr.TokenRate(token, day(2001, 11, 27)))
+	// Yearly range wrapping around the new year
+	assert.Equal(t, 0.5, cr.TokenRate(token, day(2001, 12, 31)))
+	assert.Equal(t, 0.5, cr.TokenRate(token, day(2002, 1, 1)))
+	assert.Equal(t, 1.0, cr.TokenRate(token, day(2002, 1, 2)))
+}
+
+func TestConfigRaterDateRangeOverride(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join("..", "tests", "rater", "calendarrater.yml"))
+
+	c := config.NewConfig()
+	cr := &ConfigRater{c: c.FindRater("weekend")}
+	token := config.Token{Name: "test"}
+
+	// Saturday noon outside the range dips for the weekend
+	assert.Equal(t, 1.0, cr.TokenRate(token, time.Date(2001, 11, 17, 12, 0, 0, 0, time.Local)))
+	assert.Equal(t, 0.5, cr.TokenRate(token, time.Date(2001, 11, 17, 11, 0, 0, 0, time.Local)))
+	// A range of 1.0 cancels the weekend dip and the hour of day
+	assert.Equal(t, 1.0, cr.TokenRate(token, time.Date(2001, 11, 24, 11, 0, 0, 0, time.Local)))
+	assert.Equal(t, 1.0, cr.TokenRate(token, time.Date(2001, 11, 25, 12, 0, 0, 0, time.Local)))
+}
@@ -1,6 +1,7 @@
 package rater
 
 import (
+	"context"
 	"time"
 
 	config "github.com/coccyx/gogen/internal"
@@ -16,6 +17,11 @@ type KBpsRater struct {
 
 // EventRate takes a given sample and current count and returns the rated count
 func (r *KBpsRater) EventRate(s *config.Sample, now time.Time, count int) float64 {
+	return r.EventRateContext(context.Background(), s, now, count)
+}
+
+// EventRateContext waits until the sample's output is down to KBps, returning early if ctx is cancelled
+func (r *KBpsRater) EventRateContext(ctx context.Context, s *config.Sample, now time.Time, count int) float64 {
 
 	if _, ok := r.c.Options["KBps"]; !ok {
 		log.Errorf("KBpsRater: KBps must be present")
@@ -47,7 +53,12 @@ func (r *KBpsRater) EventRate(s *config.Sample, now time.Time, count int) float6
 		log.Debugf("KBpsRater: sample=%s size=%d expected=%.2f actual=%.2f delta=%.2f",
 			s.Name, size, expected, actual, delta)
 
-		time.Sleep(time.Duration(delta) * time.Second)
+		timer := time.NewTimer(time.Duration(delta) * time.Second)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
 	}
 
 	return 1.0
@@ -1,9 +1,11 @@
 package rater
 
 import (
+	"context"
 	"math"
 	"math/rand"
 	"reflect"
+	"sync"
 	"time"
 
 	config "github.com/coccyx/gogen/internal"
@@ -12,18 +14,41 @@ import (
 
 var randGen *rand.Rand
 var randSource int64
+var randMutex sync.Mutex // Guards randGen, which is shared by every sample without its own Rand
+
+// ContextRater is implemented by raters which wait, like KBpsRater, so they stop waiting when ctx is cancelled
+type ContextRater interface {
+	EventRateContext(ctx context.Context, s *config.Sample, now time.Time, count int) float64
+}
 
 // EventRate takes a given sample and current count and returns the rated count
 func EventRate(s *config.Sample, now time.Time, count int) (ret int) {
+	return EventRateContext(context.Background(), s, now, count)
+}
+
+// EventRateContext is EventRate, but raters which wait give up when ctx is cancelled
+func EventRateContext(ctx context.Context, s *config.Sample, now time.Time, count int) (ret int) {
 	if s.Rater == nil {
-		s.Rater = GetRater(s.RaterString)
+		s.Rater = GetSampleRater(s, s.RaterString)
 		log.Infof("Setting rater to %s, type %s, for sample '%s'", s.RaterString, reflect.TypeOf(s.Rater), s.Name)
 	}
-	rate := s.Rater.EventRate(s, now, count)
+	var rate float64
+	if cr, ok := s.Rater.(ContextRater); ok {
+		rate = cr.EventRateContext(ctx, s, now, count)
+	} else {
+		rate = s.Rater.EventRate(s, now, count)
+	}
 	randFactor := float64(1.0)
 	if s.RandomizeCount != float64(0) {
 		randBound := int(math.Round(s.RandomizeCount * 1000))
-		rand := randGen.Intn(randBound)
+		var rand int
+		if s.Rand != nil {
+			rand = s.Rand.Intn(randBound)
+		} else {
+			randMutex.Lock()
+			rand = randGen.Intn(randBound)
+			randMutex.Unlock()
+		}
 		randFactor = 1 + (-(float64(randBound/2) - float64(rand)) / float64(1000))
 		rate *= randFactor
 	}
@@ -34,30 +59,54 @@ func EventRate(s *config.Sample, now time.Time, count int) (ret int) {
 		ret = int(math.Floor(ratedCount + 0.5))
 	}
 	log.Debugf("count: %d ratedCount: %.2f origCount: %d randFactor %.2f for sample '%s'", ret, ratedCount, count, randFactor, s.Name)
-	return ret
+	return s.AnomalyCount(now, ret)
 }
 
 // GetRater returns a rater interface
 func GetRater(name string) (ret config.Rater) {
-	c := config.NewConfig()
-	r := c.FindRater(name)
+	return getRater(config.NewConfig().FindRater, name)
+}
+
+// GetSampleRater returns a rater interface for a rater in the config the sample was built in
+func GetSampleRater(s *config.Sample, name string) (ret config.Rater) {
+	return getRater(s.FindRater, name)
+}
+
+func getRater(findRater func(name string) *config.RaterConfig, name string) (ret config.Rater) {
+	ret = newRater(findRater, name, make(map[string]bool))
+
+	randMutex.Lock()
+	if randSource == 0 { // Allow tests to override
+		randSource = time.Now().UnixNano()
+	}
+	randGen = rand.New(rand.NewSource(randSource))
+	randMutex.Unlock()
+	return ret
+}
+
+// newRater returns the rater for the config named name.  seen holds the chain raters it's being built for.
+func newRater(findRater func(name string) *config.RaterConfig, name string, seen map[string]bool) (ret config.Rater) {
+	r := findRater(name)
+	if r != nil && seen[r.Name] {
+		log.Errorf("Chain rater '%s' includes itself, using default rater", r.Name)
+		r = nil
+	}
 	if r == nil {
-		r := c.FindRater("default")
+		r := findRater("default")
 		ret = &DefaultRater{c: r}
 	} else if r.Name == "default" {
-		r := c.FindRater("default")
+		r := findRater("default")
 		ret = &DefaultRater{c: r}
 	} else if r.Type == "config" {
 		ret = &ConfigRater{c: r}
 	} else if r.Type == "kbps" {
 		ret = &KBpsRater{c: r}
+	} else if r.Type == "timeseries" {
+		ret = &TimeSeriesRater{c: r}
+	} else if r.Type == "chain" {
+		ret = newChainRater(findRater, r, seen)
 	} else {
 		ret = &ScriptRater{c: r}
 	}
-
-	if randSource == 0 { // Allow tests to override
-		randSource = time.Now().UnixNano()
-	}
-	randGen = rand.New(rand.NewSource(randSource))
 	return ret
 }
@@ -1,6 +1,8 @@
 package rater
 
 import (
+	"strconv"
+	"sync"
 	"time"
 
 	config "github.com/coccyx/gogen/internal"
@@ -15,32 +17,71 @@ type ScriptRater struct {
 
 	L        *lua.LState
 	luaState *lua.LTable
+	script   *config.LuaScript
+	once     sync.Once
 }
 
-// GetRate acts as a general method for EventRate and TokenRate
-func (sr *ScriptRater) getRate(now time.Time) float64 {
-	if sr.luaState == nil {
+// GetRate acts as a general method for EventRate and TokenRate.  The script gets the time being rated, the
+// names of the sample and token, and for events, the count before rating as globals.  Globals which don't
+// apply are nil.
+func (sr *ScriptRater) getRate(now time.Time, sample string, token string, count lua.LValue) float64 {
+	sr.once.Do(func() {
 		sr.luaState = new(lua.LTable)
 		for k, v := range sr.c.Init {
-			sr.luaState.RawSet(lua.LString(k), lua.LString(v))
+			vAsNum, err := strconv.ParseFloat(v, 64)
+			if err == nil {
+				sr.luaState.RawSet(lua.LString(k), lua.LNumber(vAsNum))
+			} else {
+				sr.luaState.RawSet(lua.LString(k), lua.LString(v))
+			}
 		}
+		var err error
+		sr.script, err = config.NewLuaScript(sr.c.Name, sr.c.Script, sr.luaState, func(L *lua.LState) {
+			L.SetGlobal("options", luar.New(L, sr.c.Options))
+		})
+		if err != nil {
+			log.Errorf("Error parsing script for rater '%s': %s", sr.c.Name, err)
+		}
+	})
+	if sr.script == nil {
+		return 0
 	}
-	L := lua.NewState()
-	defer L.Close()
-	L.SetGlobal("state", sr.luaState)
-	L.SetGlobal("options", luar.New(L, sr.c.Options))
-	if err := L.DoString(sr.c.Script); err != nil {
+	ret, err := sr.script.Run(func(L *lua.LState) {
+		L.SetGlobal("now", lua.LNumber(float64(now.UnixNano())/float64(time.Second)))
+		L.SetGlobal("minuteOfHour", lua.LNumber(now.Minute()))
+		L.SetGlobal("hourOfDay", lua.LNumber(now.Hour()))
+		L.SetGlobal("dayOfWeek", lua.LNumber(now.Weekday()))
+		L.SetGlobal("dayOfMonth", lua.LNumber(now.Day()))
+		L.SetGlobal("monthOfYear", lua.LNumber(now.Month()))
+		L.SetGlobal("year", lua.LNumber(now.Year()))
+		L.SetGlobal("sample", luaString(sample))
+		L.SetGlobal("token", luaString(token))
+		L.SetGlobal("count", count)
+	})
+	if err != nil {
 		log.Errorf("Error executing script for rater '%s': %s", sr.c.Name, err)
 	}
-	return float64(lua.LVAsNumber(L.Get(-1)))
+	return float64(lua.LVAsNumber(ret))
+}
+
+// luaString returns s as a Lua string, or nil if it's empty
+func luaString(s string) lua.LValue {
+	if s == "" {
+		return lua.LNil
+	}
+	return lua.LString(s)
 }
 
 // EventRate takes a given sample and current count and returns the rated count
 func (sr *ScriptRater) EventRate(s *config.Sample, now time.Time, count int) float64 {
-	return sr.getRate(now)
+	return sr.getRate(now, s.Name, "", lua.LNumber(count))
 }
 
 // TokenRate takes a token and returns the rated value
 func (sr *ScriptRater) TokenRate(t config.Token, now time.Time) float64 {
-	return sr.getRate(now)
+	var sample string
+	if t.Parent != nil {
+		sample = t.Parent.Name
+	}
+	return sr.getRate(now, sample, t.Name, lua.LNil)
 }
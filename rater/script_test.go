@@ -28,3 +28,38 @@ func TestScriptRaterEventRate(t *testing.T) {
 	assert.True(t, assert.ObjectsAreEqual(r, s.Rater.(*ScriptRater).c))
 	assert.Equal(t, 2, ret)
 }
+
+func TestScriptRaterContext(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join("..", "tests", "rater", "luacontextrater.yml"))
+
+	c := config.NewConfig()
+	s := c.FindSampleByName("context")
+	sr := &ScriptRater{c: c.FindRater("timeaware")}
+
+	monday := time.Date(2001, 10, 22, 10, 0, 0, 0, time.Local)
+	saturday := time.Date(2001, 10, 20, 10, 0, 0, 0, time.Local)
+	christmas := time.Date(2001, 12, 25, 8, 30, 0, 0, time.UTC)
+	assert.Equal(t, 2.0, sr.EventRate(s, monday, 10))
+	assert.Equal(t, 1.0, sr.EventRate(s, monday, 5))
+	assert.Equal(t, 1.0, sr.EventRate(s, saturday, 10))
+	assert.Equal(t, 0.5, sr.EventRate(s, christmas, 1))
+	assert.Equal(t, 3.0, sr.TokenRate(s.Tokens[0], monday))
+	assert.Equal(t, 0.0, sr.TokenRate(config.Token{Name: "rated"}, monday))
+	// Globals from the last call don't leak into the next
+	assert.Equal(t, 2.0, sr.EventRate(s, monday, 10))
+}
+
+func TestScriptRaterInitNumbers(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join("..", "tests", "rater", "luacontextrater.yml"))
+
+	c := config.NewConfig()
+	sr := &ScriptRater{c: c.FindRater("stateful")}
+	token := config.Token{Name: "test"}
+	assert.Equal(t, 1.5, sr.TokenRate(token, time.Now()))
+	assert.Equal(t, 3.0, sr.TokenRate(token, time.Now()))
+	assert.Equal(t, 4.0, sr.TokenRate(token, time.Now()))
+}
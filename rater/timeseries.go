@@ -0,0 +1,30 @@
+package rater
+
+import (
+	"time"
+
+	config "github.com/coccyx/gogen/internal"
+)
+
+// TimeSeriesRater rates by a curve of multipliers loaded from a CSV, like a traffic curve exported from production
+type TimeSeriesRater struct {
+	c *config.RaterConfig
+}
+
+// getRate acts as a general method for EventRate and TokenRate
+func (r *TimeSeriesRater) getRate(now time.Time) float64 {
+	if r.c.TimeSeries == nil {
+		return 1.0
+	}
+	return r.c.TimeSeries.Rate(now)
+}
+
+// EventRate takes a given sample and current count and returns the rated count
+func (r *TimeSeriesRater) EventRate(s *config.Sample, now time.Time, count int) float64 {
+	return r.getRate(now)
+}
+
+// TokenRate takes a token and returns the rated value
+func (r *TimeSeriesRater) TokenRate(t config.Token, now time.Time) float64 {
+	return r.getRate(now)
+}
@@ -0,0 +1,38 @@
+package rater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	config "github.com/coccyx/gogen/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeSeriesRater(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	os.Setenv("GOGEN_FULLCONFIG", filepath.Join("..", "tests", "rater", "timeseriesrater.yml"))
+
+	c := config.NewConfig()
+	s := c.FindSampleByName("timeseriesrater")
+	assert.NotNil(t, c.FindRater("timeseriesrater").TimeSeries)
+
+	n := time.Date(2001, 10, 20, 0, 30, 0, 0, time.UTC)
+	assert.Equal(t, 4, EventRate(s, n, 2))
+	n = time.Date(2001, 10, 21, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 4, EventRate(s, n, 2))
+
+	sr := &TimeSeriesRater{c: c.FindRater("steprater")}
+	token := config.Token{Name: "test"}
+	n = time.Date(2001, 10, 21, 11, 59, 0, 0, time.Local) // Sunday
+	assert.Equal(t, 1.0, sr.TokenRate(token, n))
+	n = time.Date(2001, 10, 27, 23, 0, 0, 0, time.Local) // Saturday
+	assert.Equal(t, 5.0, sr.TokenRate(token, n))
+}
+
+func TestTimeSeriesRaterNoFile(t *testing.T) {
+	tr := &TimeSeriesRater{c: &config.RaterConfig{Name: "timeseries", Type: "timeseries"}}
+	assert.Equal(t, 1.0, tr.TokenRate(config.Token{Name: "test"}, time.Now()))
+}
@@ -1,6 +1,7 @@
 package run
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
@@ -23,8 +24,17 @@ func ROT(c *config.Config, gq chan *config.GenQueueItem, oq chan *config.OutQueu
 	}
 }
 
-// Run runs the mainline of the program
+// Run runs the mainline of the program until every timer is done or we're interrupted
 func Run(c *config.Config) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	RunContext(ctx, c)
+}
+
+// RunContext runs the mainline of the program until every timer is done or ctx is cancelled.  On
+// cancellation, timers stop immediately and queued work which hasn't been generated yet is skipped, but
+// every generated event is still output and outputs are flushed before returning.
+func RunContext(ctx context.Context, c *config.Config) {
 	log.Info("Starting ReadOutThread")
 	go outputter.ROT(c)
 	log.Info("Starting Timers")
@@ -40,7 +50,7 @@ func Run(c *config.Config) {
 		s := c.Samples[i]
 		if !s.Disabled {
 			t := timer.Timer{S: s, GQ: gq, OQ: oq, Done: timerdone}
-			go t.NewTimer(c.Global.CacheIntervals)
+			go t.NewTimer(ctx, c.Global.CacheIntervals)
 			timers = append(timers, &t)
 		}
 	}
@@ -49,57 +59,31 @@ func Run(c *config.Config) {
 	log.Infof("Starting Generators")
 	for i := 0; i < c.Global.GeneratorWorkers; i++ {
 		log.Infof("Starting Generator %d", i)
-		go generator.Start(gq, gqs)
+		go generator.Start(ctx, gq, gqs)
 		gens++
 	}
 
 	log.Infof("Starting Outputters")
 	for i := 0; i < c.Global.OutputWorkers; i++ {
 		log.Infof("Starting Outputter %d", i)
-		go outputter.Start(oq, oqs, i)
+		go outputter.Start(ctx, oq, oqs, i)
 		outs++
 	}
 
 	go ROT(c, gq, oq)
 
-	// time.Sleep(1000 * time.Millisecond)
-
-	// Check if any timers are done
-	donechan := make(chan bool)
-	go func() {
-		timerCount := len(timers)
-		for {
-			select {
-			case <-timerdone:
-				timerCount--
-				log.Debugf("Timer done, timers left %d", timerCount)
-				if timerCount == 0 {
-					log.Infof("Timers all done, closing generating queue")
-					donechan <- true
-				}
-			}
-		}
-	}()
-
-	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, os.Interrupt)
-	signal.Notify(sigchan, syscall.SIGTERM)
-	go func() {
-		for range sigchan {
-			log.Infof("Caught interrupt, shutting down")
-			// Shut down timers
-			for _, t := range timers {
-				t.Close()
-			}
-			// Drain generator queue
-			for range gq {
-				continue
-			}
-		}
-	}()
+	// Wait for all the timers to be done, which they will be promptly once ctx is cancelled
+	for timerCount := len(timers); timerCount > 0; timerCount-- {
+		<-timerdone
+		log.Debugf("Timer done, timers left %d", timerCount-1)
+	}
+	if ctx.Err() != nil {
+		log.Infof("Caught interrupt, shutting down")
+	} else {
+		log.Infof("Timers all done, closing generating queue")
+	}
 
 	// Close our channels to signal to the workers to shut down when the queue is clear
-	<-donechan
 	close(gq)
 
 	// Check for all the workers to signal back they're done
@@ -138,5 +122,7 @@ Loop3:
 
 	// time.Sleep(100 * time.Millisecond)
 
+	c.CloseAnomalyLabels()
+	c.CloseSequences()
 	outputter.ReadFinal()
 }
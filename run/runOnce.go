@@ -1,6 +1,7 @@
 package run
 
 import (
+	"context"
 	"math/rand"
 	"time"
 
@@ -24,8 +25,10 @@ func (r Runner) Once(name string) {
 func (r Runner) onceWithConfig(name string, c *config.Config) {
 	s := c.FindSampleByName(name)
 
-	source := rand.NewSource(time.Now().UnixNano())
-	randgen := rand.New(source)
+	randgen := s.ItemRand()
+	if randgen == nil {
+		randgen = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
 	// Generate one event for our named sample
 	if s.Description == "" {
 		log.Fatalf("Description not set for sample '%s'", s.Name)
@@ -42,9 +45,10 @@ func (r Runner) onceWithConfig(name string, c *config.Config) {
 	oqs := make(chan int)
 
 	// Start outputter first so it's ready to receive
-	go outputter.Start(oq, oqs, 1)
+	ctx := context.Background()
+	go outputter.Start(ctx, oq, oqs, 1)
 	// Then start generator
-	go generator.Start(gq, gqs)
+	go generator.Start(ctx, gq, gqs)
 
 	// Get current time for event generation
 	now := time.Now()
@@ -68,40 +72,17 @@ func (r Runner) onceWithConfig(name string, c *config.Config) {
 	}
 	gq <- gqi
 
-	// Close generator and wait for it to finish
+	// Close the generator and wait for it to finish, which means the event is in the output queue
 	log.Debugf("Closing generator channel")
 	close(gq)
+	<-gqs
+	log.Debugf("Generator closed")
 
-Loop1:
-	for {
-		select {
-		case <-gqs:
-			log.Debugf("Generator closed")
-			break Loop1
-		case <-time.After(2 * time.Second):
-			log.Debugf("Generator timeout waiting for close signal")
-			break Loop1
-		}
-	}
-
-	// Give outputter time to process any remaining items
-	time.Sleep(100 * time.Millisecond)
-
-	// Now close outputter and wait for it to finish
+	// Now close the outputter and wait for it to write the event
 	log.Debugf("Closing outputter channel")
 	close(oq)
-
-Loop2:
-	for {
-		select {
-		case <-oqs:
-			log.Debugf("Outputter closed")
-			break Loop2
-		case <-time.After(2 * time.Second):
-			log.Debugf("Outputter timeout waiting for close signal")
-			break Loop2
-		}
-	}
+	<-oqs
+	log.Debugf("Outputter closed")
 
 	s.Output.Outputter = origOutputter
 	s.Output.OutputTemplate = origOutputTemplate
@@ -0,0 +1,198 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	config "github.com/coccyx/gogen/internal"
+	"github.com/coccyx/gogen/run"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnomalies(t *testing.T) {
+	labelsFile := filepath.Join(t.TempDir(), "labels.json")
+	// Setup environment
+	config.ResetConfig()
+	config.SetupFromString(fmt.Sprintf(`
+global:
+  anomalyLabels: %s
+  output:
+    outputter: buf
+samples:
+  - name: web
+    begin: "2001-10-20 12:00:00"
+    end: "2001-10-20 12:10:00"
+    interval: 60
+    count: 2
+    tokens:
+    - name: ts
+      format: template
+      type: timestamp
+      replacement: "%%H:%%M"
+    - name: status
+      format: template
+      type: choice
+      choice:
+      - "200"
+    lines:
+    - _raw: $ts$ status=$status$
+anomalies:
+  - name: spike
+    type: volume
+    begin: +2m
+    duration: 1m
+    multiplier: 10
+  - name: down
+    type: outage
+    samples:
+    - web
+    begin: "2001-10-20 12:04:00"
+    end: "2001-10-20 12:06:00"
+  - name: errors
+    type: distribution
+    token: status
+    begin: +7m
+    duration: 1m
+    choice:
+    - "500"
+  - name: rare
+    type: burst
+    begin: +8m
+    duration: 1m
+    count: 3
+    lines:
+    - _raw: $ts$ rare event
+`, labelsFile))
+
+	c := config.NewConfig()
+	run.Run(c)
+
+	perMinute := make(map[string][]string)
+	for _, l := range strings.Split(strings.TrimSpace(c.Buf.String()), "\n") {
+		f := strings.SplitN(l, " ", 2)
+		perMinute[f[0]] = append(perMinute[f[0]], f[1])
+	}
+	assert.Len(t, perMinute["12:01"], 2)
+	assert.Len(t, perMinute["12:02"], 20)
+	assert.Len(t, perMinute["12:04"], 0)
+	assert.Len(t, perMinute["12:05"], 0)
+	assert.Equal(t, []string{"status=500", "status=500"}, perMinute["12:07"])
+	assert.Equal(t, []string{"status=200", "status=200", "rare event", "rare event", "rare event"}, perMinute["12:08"])
+	config.CleanupConfigAndEnvironment()
+
+	f, err := os.Open(labelsFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	var labels []config.AnomalyLabel
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var l config.AnomalyLabel
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &l))
+		labels = append(labels, l)
+	}
+	if !assert.Len(t, labels, 9) {
+		return
+	}
+	windows := make(map[string]config.AnomalyLabel)
+	events := make(map[string][]config.AnomalyLabel)
+	for _, l := range labels {
+		if l.Label == "window" {
+			windows[l.Anomaly] = l
+		} else {
+			events[l.Anomaly] = append(events[l.Anomaly], l)
+		}
+	}
+	assert.Len(t, windows, 4)
+	assert.Equal(t, "web", windows["spike"].Sample)
+	assert.Contains(t, windows["down"].Begin, "2001-10-20T12:04:00")
+	assert.Contains(t, windows["down"].End, "2001-10-20T12:06:00")
+	assert.Contains(t, windows["errors"].Begin, "2001-10-20T12:07:00")
+
+	assert.Len(t, events["spike"], 1)
+	assert.Equal(t, 20, *events["spike"][0].Events)
+	assert.Equal(t, 2, *events["spike"][0].Expected)
+	assert.Len(t, events["down"], 2)
+	assert.Equal(t, 0, *events["down"][0].Events)
+	assert.Len(t, events["errors"], 1)
+	assert.Equal(t, 2, *events["errors"][0].Events)
+	assert.Len(t, events["rare"], 1)
+	assert.Equal(t, 3, *events["rare"][0].Events)
+	assert.Nil(t, events["rare"][0].Expected)
+}
+
+func TestAnomaliesCacheIntervals(t *testing.T) {
+	// Setup environment
+	config.ResetConfig()
+	config.SetupFromString(`
+global:
+  cacheIntervals: 100
+  output:
+    outputter: buf
+samples:
+  - name: web
+    begin: "2001-10-20 12:00:00"
+    end: "2001-10-20 12:10:00"
+    interval: 60
+    count: 2
+    tokens:
+    - name: ts
+      format: template
+      type: timestamp
+      replacement: "%H:%M"
+    - name: status
+      format: template
+      type: choice
+      choice:
+      - "200"
+    lines:
+    - _raw: $ts$ status=$status$
+anomalies:
+  - name: spike
+    type: volume
+    begin: +2m
+    duration: 1m
+    multiplier: 10
+  - name: down
+    type: outage
+    begin: "2001-10-20 12:04:00"
+    end: "2001-10-20 12:06:00"
+  - name: errors
+    type: distribution
+    token: status
+    begin: +7m
+    duration: 1m
+    choice:
+    - "500"
+  - name: rare
+    type: burst
+    begin: +8m
+    duration: 1m
+    count: 3
+    lines:
+    - _raw: $ts$ rare event
+`)
+
+	c := config.NewConfig()
+	run.Run(c)
+
+	// Intervals without anomalies resend the events cached at 12:00
+	counts := make(map[string]int)
+	for _, l := range strings.Split(strings.TrimSpace(c.Buf.String()), "\n") {
+		counts[l]++
+	}
+	assert.Equal(t, map[string]int{
+		"12:00 status=200": 10,
+		"12:02 status=200": 20,
+		"12:07 status=500": 2,
+		"12:08 status=200": 2,
+		"12:08 rare event": 3,
+	}, counts)
+	config.CleanupConfigAndEnvironment()
+}
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coccyx/gogen/generator"
+	config "github.com/coccyx/gogen/internal"
+	"github.com/coccyx/gogen/outputter"
+	"github.com/coccyx/gogen/run"
+	"github.com/stretchr/testify/assert"
+)
+
+type countGen struct{}
+
+func (g countGen) Gen(item *generator.GenQueueItem) error {
+	events := make([]map[string]string, item.Count)
+	for i := range events {
+		events[i] = map[string]string{"_raw": strings.Repeat("x", i+1)}
+	}
+	generator.Send(item, events)
+	return nil
+}
+
+type collectOut struct{}
+
+var (
+	collected      []string
+	collectedMutex sync.Mutex
+)
+
+func (o collectOut) Send(item *outputter.OutQueueItem) error {
+	collectedMutex.Lock()
+	defer collectedMutex.Unlock()
+	for _, e := range item.Events {
+		collected = append(collected, item.S.Name+":"+e["_raw"])
+	}
+	return nil
+}
+
+func (o collectOut) Close() error {
+	return nil
+}
+
+func init() {
+	generator.RegisterGenerator("countgen", func() generator.Generator { return countGen{} })
+	outputter.RegisterOutputter("collect", func() outputter.Outputter { return collectOut{} })
+}
+
+func TestRegisteredGeneratorAndOutputter(t *testing.T) {
+	collectedMutex.Lock()
+	collected = nil
+	collectedMutex.Unlock()
+	// Setup environment
+	config.ResetConfig()
+	config.SetupFromString(`
+global:
+  output:
+    outputter: collect
+samples:
+  - name: native
+    generator: countgen
+    count: 3
+    endIntervals: 1
+`)
+
+	c := config.NewConfig()
+	assert.NotNil(t, c.FindSampleByName("native"))
+	run.Run(c)
+
+	collectedMutex.Lock()
+	assert.Equal(t, []string{"native:x", "native:xx", "native:xxx"}, collected)
+	collectedMutex.Unlock()
+	config.CleanupConfigAndEnvironment()
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	assert.Panics(t, func() {
+		generator.RegisterGenerator("replay", func() generator.Generator { return countGen{} })
+	})
+	assert.Panics(t, func() {
+		outputter.RegisterOutputter("stdout", func() outputter.Outputter { return collectOut{} })
+	})
+	assert.Panics(t, func() {
+		generator.RegisterGenerator("countgen", func() generator.Generator { return countGen{} })
+	})
+}
@@ -45,3 +45,147 @@ samples:
 `, c.Buf.String())
 	config.CleanupConfigAndEnvironment()
 }
+
+func TestReplaySpeed(t *testing.T) {
+	// Setup environment
+	config.ResetConfig()
+	config.SetupFromString(`
+global:
+  output:
+    outputter: buf
+samples:
+  - name: fastreplay
+    generator: replay
+    replaySpeed: 2
+    replayMaxGap: 4s
+    begin: "2001-10-20 12:00:00"
+    end: "2001-10-20 12:00:14"
+    tokens:
+    - name: ts1
+      type: timestamp
+      replacement: "%Y-%m-%dT%H:%M:%S"
+      format: regex
+      token: "(\\d{4}-\\d{2}-\\d{2}T\\d{2}:\\d{2}:\\d{2})"
+    lines:
+    - "_raw": "2001-10-20T12:00:00"
+    - "_raw": "2001-10-20T12:00:02"
+    - "_raw": "2001-10-20T12:00:06"
+    - "_raw": "2001-10-20T12:00:16"
+    - "_raw": "2001-10-20T12:00:36"
+`)
+
+	c := config.NewConfig()
+	run.Run(c)
+
+	assert.Equal(t, `2001-10-20T12:00:00
+2001-10-20T12:00:01
+2001-10-20T12:00:03
+2001-10-20T12:00:07
+2001-10-20T12:00:11
+`, c.Buf.String())
+	config.CleanupConfigAndEnvironment()
+}
+
+func TestReplayStream(t *testing.T) {
+	// Setup environment
+	config.ResetConfig()
+	config.SetupFromString(`
+global:
+  output:
+    outputter: buf
+samples:
+  - name: streamreplay
+    generator: replay
+    replayFile: replay/stream.log
+    replayEOF: stop
+    begin: "2001-10-20 12:00:00"
+    tokens:
+    - name: ts1
+      type: timestamp
+      replacement: "%Y-%m-%dT%H:%M:%S"
+      format: regex
+      token: "(\\d{4}-\\d{2}-\\d{2}T\\d{2}:\\d{2}:\\d{2})"
+`)
+
+	c := config.NewConfig()
+	run.Run(c)
+
+	assert.Equal(t, `2001-10-20T12:00:00 first
+2001-10-20T12:00:01 second
+  continuation of second
+2001-10-20T12:00:06 third
+2001-10-20T12:00:16 fourth
+2001-10-20T12:00:36 fifth
+`, c.Buf.String())
+	config.CleanupConfigAndEnvironment()
+}
+
+func TestReplayStreamGzipLoop(t *testing.T) {
+	// Setup environment
+	config.ResetConfig()
+	config.SetupFromString(`
+global:
+  output:
+    outputter: buf
+samples:
+  - name: gzipreplay
+    generator: replay
+    replayFile: replay/stream.log.gz
+    begin: "2001-10-20 12:00:00"
+    end: "2001-10-20 12:01:10"
+    tokens:
+    - name: ts1
+      type: timestamp
+      replacement: "%Y-%m-%dT%H:%M:%S"
+      format: regex
+      token: "(\\d{4}-\\d{2}-\\d{2}T\\d{2}:\\d{2}:\\d{2})"
+`)
+
+	c := config.NewConfig()
+	run.Run(c)
+
+	assert.Equal(t, `2001-10-20T12:00:00 first
+2001-10-20T12:00:01 second
+  continuation of second
+2001-10-20T12:00:06 third
+2001-10-20T12:00:16 fourth
+2001-10-20T12:00:36 fifth
+2001-10-20T12:00:43 first
+2001-10-20T12:00:44 second
+  continuation of second
+2001-10-20T12:00:49 third
+2001-10-20T12:00:59 fourth
+`, c.Buf.String())
+	config.CleanupConfigAndEnvironment()
+}
+
+func TestReplayStreamOpenedWhenGenerating(t *testing.T) {
+	// Setup environment
+	config.ResetConfig()
+	config.SetupFromString(`
+global:
+  output:
+    outputter: buf
+samples:
+  - name: emptyreplay
+    generator: replay
+    replayFile: replay/empty.log
+    begin: "2001-10-20 12:00:00"
+    end: "2001-10-20 12:01:00"
+    tokens:
+    - name: ts1
+      type: timestamp
+      replacement: "%Y-%m-%dT%H:%M:%S"
+      format: regex
+      token: "(\\d{4}-\\d{2}-\\d{2}T\\d{2}:\\d{2}:\\d{2})"
+`)
+
+	// Building the config doesn't read the file, only generating does
+	c := config.NewConfig()
+	s := c.FindSampleByName("emptyreplay")
+	assert.False(t, s.Disabled)
+	assert.NotNil(t, s.ReplayStream)
+	_, _, err := s.ReplayStream.Next()
+	assert.Error(t, err)
+	config.CleanupConfigAndEnvironment()
+}
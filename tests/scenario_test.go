@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	config "github.com/coccyx/gogen/internal"
+	"github.com/coccyx/gogen/run"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScenario(t *testing.T) {
+	// Setup environment
+	config.ResetConfig()
+	config.SetupFromString(`
+global:
+  output:
+    outputter: buf
+samples:
+  - name: bruteforce
+    generator: scenario
+    begin: "2001-10-20 12:01:00"
+    end: "2001-10-20 12:02:00"
+    interval: 60
+    count: 2
+    scenario:
+      vars:
+      - user
+      states:
+      - name: failed
+        repeat: 3
+        delay: 2s
+        lines:
+        - _raw: $ts$ failed $user$
+        transitions:
+        - to: success
+          delay: 1s
+      - name: success
+        lines:
+        - _raw: $ts$ success $user$
+    tokens:
+    - name: ts
+      format: template
+      type: timestamp
+      replacement: "%Y-%m-%dT%H:%M:%S"
+    - name: user
+      format: template
+      type: random
+      replacement: string
+      length: 12
+`)
+
+	c := config.NewConfig()
+	run.Run(c)
+
+	lines := strings.Split(strings.TrimSpace(c.Buf.String()), "\n")
+	if !assert.Len(t, lines, 8) {
+		return
+	}
+	// Each instance keeps its user across its events, which are in time order
+	events := make(map[string][]string)
+	last := ""
+	for _, l := range lines {
+		f := strings.Fields(l)
+		assert.True(t, strings.HasPrefix(f[0], "2001-10-20T12:0"), l)
+		assert.GreaterOrEqual(t, f[0], last)
+		last = f[0]
+		events[f[2]] = append(events[f[2]], f[1])
+	}
+	assert.Len(t, events, 2)
+	// Instances start in the interval before the item's time
+	assert.Less(t, lines[0], "2001-10-20T12:01:00")
+	for _, states := range events {
+		assert.Equal(t, []string{"failed", "failed", "failed", "success"}, states)
+	}
+	config.CleanupConfigAndEnvironment()
+}
+
+func TestScenarioWorkers(t *testing.T) {
+	// Setup environment
+	config.ResetConfig()
+	config.SetupFromString(`
+global:
+  generatorWorkers: 4
+  output:
+    outputter: buf
+samples:
+  - name: bruteforce
+    generator: scenario
+    begin: "2001-10-20 12:00:00"
+    end: "2001-10-20 12:10:00"
+    interval: 60
+    count: 3
+    scenario:
+      vars:
+      - user
+      states:
+      - name: failed
+        repeat: 3
+        delay: 20s
+        lines:
+        - _raw: $ts$ failed $user$
+        transitions:
+        - to: success
+          delay: 30s
+      - name: success
+        lines:
+        - _raw: $ts$ success $user$
+    tokens:
+    - name: ts
+      format: template
+      type: timestamp
+      replacement: "%Y-%m-%dT%H:%M:%S"
+    - name: user
+      format: template
+      type: random
+      replacement: string
+      length: 12
+`)
+
+	c := config.NewConfig()
+	run.Run(c)
+
+	// However workers order the intervals, every instance runs to the end
+	lines := strings.Split(strings.TrimSpace(c.Buf.String()), "\n")
+	sort.Strings(lines)
+	events := make(map[string][]string)
+	for _, l := range lines {
+		f := strings.Fields(l)
+		events[f[2]] = append(events[f[2]], f[1])
+	}
+	assert.Len(t, events, 30)
+	for _, states := range events {
+		assert.Equal(t, []string{"failed", "failed", "failed", "success"}, states)
+	}
+	config.CleanupConfigAndEnvironment()
+}
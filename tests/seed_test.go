@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	config "github.com/coccyx/gogen/internal"
+	"github.com/coccyx/gogen/run"
+	"github.com/stretchr/testify/assert"
+)
+
+func seededLuaRun(seed int64) string {
+	config.ResetConfig()
+	config.SetupFromString(fmt.Sprintf(`
+global:
+  seed: %d
+  generatorWorkers: 4
+  output:
+    outputter: buf
+generators:
+  - name: luarandom
+    script: >
+        local events = {}
+        for i = 1, count do
+            events[i] = { _raw = "gen=" .. math.random(1000000) }
+        end
+        send(events)
+samples:
+  - name: luagen
+    generator: luarandom
+    begin: "2001-10-20 12:00:00"
+    end: "2001-10-20 12:00:10"
+    interval: 1
+    count: 2
+  - name: luatoken
+    begin: "2001-10-20 12:00:00"
+    end: "2001-10-20 12:00:10"
+    interval: 1
+    count: 2
+    tokens:
+    - name: script
+      format: template
+      type: script
+      script: >
+        return math.random(1000000)
+    lines:
+    - _raw: token=$script$
+`, seed))
+	c := config.NewConfig()
+	run.Run(c)
+	// Workers finish items in any order, so compare the events regardless of order
+	ret := strings.Split(strings.TrimSpace(c.Buf.String()), "\n")
+	sort.Strings(ret)
+	config.CleanupConfigAndEnvironment()
+	return strings.Join(ret, "\n")
+}
+
+func TestSeededLua(t *testing.T) {
+	a := seededLuaRun(42)
+	assert.Contains(t, a, "gen=")
+	assert.Contains(t, a, "token=")
+	assert.Equal(t, a, seededLuaRun(42))
+	assert.NotEqual(t, a, seededLuaRun(43))
+}
@@ -1,6 +1,9 @@
 package timer
 
 import (
+	"context"
+	"io"
+	"sync"
 	"time"
 
 	config "github.com/coccyx/gogen/internal"
@@ -16,15 +19,34 @@ type Timer struct {
 	GQ             chan *config.GenQueueItem
 	OQ             chan *config.OutQueueItem
 	Done           chan int
+	ctx            context.Context
+	cancel         context.CancelFunc
 	closed         bool
-	cacheCounter   int // Number of intervals left to use cache
-	cacheIntervals int // Number of intervals to cache for
+	mutex          sync.Mutex    // Guards cancel and closed, as Close can be called from other goroutines
+	cacheCounter   int           // Number of intervals left to use cache
+	cacheIntervals int           // Number of intervals to cache for
+	cache          *config.EventCache
+	gap            time.Duration // For replay, how long to wait after the event last queued
 }
 
-// NewTimer creates a new Timer for a sample which will put work into the generator queue on each interval
-func (t *Timer) NewTimer(cacheIntervals int) {
+// NewTimer creates a new Timer for a sample which will put work into the generator queue on each interval.
+// It returns promptly once ctx is cancelled or Close is called.
+func (t *Timer) NewTimer(ctx context.Context, cacheIntervals int) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	t.mutex.Lock()
+	t.ctx = ctx
+	t.cancel = cancel
+	if t.closed {
+		cancel()
+	}
+	t.mutex.Unlock()
+
 	s := t.S
 	t.cacheIntervals = cacheIntervals
+	if cacheIntervals > 0 {
+		t.cache = new(config.EventCache)
+	}
 	// If we're not realtime, then we should be backfilling
 	if !s.Realtime {
 		// Set the end time based on configuration, either now or a specified time in the config
@@ -47,42 +69,18 @@ func (t *Timer) NewTimer(cacheIntervals int) {
 	if !t.S.Realtime {
 		t.backfill(s.EndParsed)
 	}
-	// In realtime mode, continue until we get an interrupt
+	// In realtime mode, continue until we're cancelled
 	if s.Realtime {
-		for {
+		for ctx.Err() == nil {
 			if s.Generator == "replay" {
 				t.genWork()
-				time.Sleep(s.ReplayOffsets[t.cur])
+				t.wait(t.gap)
 				t.cur++
 				if t.cur >= len(s.ReplayOffsets) {
 					t.cur = 0
 				}
-			} else {
-				if s.Interval > 5 {
-					// For longer intervals, use ticker to check closed status
-					mainTimer := time.NewTimer(time.Duration(s.Interval) * time.Second)
-					checkTicker := time.NewTicker(1 * time.Second)
-					select {
-					case <-mainTimer.C:
-						checkTicker.Stop()
-						t.genWork()
-					case <-checkTicker.C:
-						if t.closed {
-							mainTimer.Stop()
-							checkTicker.Stop()
-							break
-						}
-						continue
-					}
-				} else {
-					// For short intervals, just use the timer directly
-					timer := time.NewTimer(time.Duration(s.Interval) * time.Second)
-					<-timer.C
-					t.genWork()
-				}
-			}
-			if t.closed {
-				break
+			} else if t.wait(time.Duration(s.Interval) * time.Second) {
+				t.genWork()
 			}
 		}
 	}
@@ -90,12 +88,21 @@ func (t *Timer) NewTimer(cacheIntervals int) {
 }
 
 func (t *Timer) backfill(until time.Time) {
-	for t.S.Current.Before(until) {
+	for t.S.Current.Before(until) && t.ctx.Err() == nil {
 		t.genWork()
 		t.inc()
-		if t.closed {
-			break
-		}
+	}
+}
+
+// wait sleeps for d, returning false if the timer was cancelled first
+func (t *Timer) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-t.ctx.Done():
+		return false
 	}
 }
 
@@ -109,41 +116,58 @@ func (t *Timer) genWork() {
 	if t.cacheCounter < 0 {
 		t.cacheCounter = t.cacheIntervals
 	}
+	// Cached events would leave out active anomalies, so generate fresh events without caching them
+	if s.AnomalyActive(now) {
+		useCache = false
+		setCache = false
+	}
 	ci := &config.CacheItem{
 		UseCache: useCache,
 		SetCache: setCache,
+		Events:   t.cache,
 	}
 	if s.Generator == "replay" {
 		earliest := now
 		latest := now
 		count := 1
 		item = &config.GenQueueItem{S: s, Count: count, Event: t.cur, Earliest: earliest, Latest: latest, Now: now, OQ: t.OQ, Cache: ci}
+		if s.ReplayStream != nil {
+			line, gap, err := s.ReplayStream.Next()
+			if err != nil {
+				if err == io.EOF {
+					log.Infof("Reached end of replay file for sample '%s'", s.Name)
+				} else {
+					log.Errorf("Error replaying sample '%s': %s", s.Name, err)
+				}
+				t.gap = 0
+				t.cancel()
+				return
+			}
+			item.Line = line
+			t.gap = s.ScaleReplayGap(gap)
+		} else {
+			t.gap = s.ReplayGap(t.cur)
+		}
 	} else {
 		earliest := now.Add(s.EarliestParsed)
 		latest := now.Add(s.LatestParsed)
-		count := rater.EventRate(s, now, s.Count)
+		count := rater.EventRateContext(t.ctx, s, now, s.Count)
 		item = &config.GenQueueItem{S: s, Count: count, Event: -1, Earliest: earliest, Latest: latest, Now: now, OQ: t.OQ, Cache: ci}
 	}
+	// If seeded, draw this item's random source here so generation doesn't depend on which worker picks it up
+	item.Rand = s.ItemRand()
 	// log.Debugf("Placing item in queue for sample '%s': %#v", t.S.Name, item)
-Loop1:
-	for {
-		select {
-		case t.GQ <- item:
-			break Loop1
-		case <-time.After(1 * time.Second):
-			if t.closed {
-				log.Debugf("Timer %s closed", t.S.Name)
-				break Loop1
-			}
-			continue
-		}
+	select {
+	case t.GQ <- item:
+	case <-t.ctx.Done():
+		log.Debugf("Timer %s closed", t.S.Name)
 	}
 }
 
 func (t *Timer) inc() {
 	s := t.S
 	if s.Generator == "replay" {
-		s.Current = s.Current.Add(s.ReplayOffsets[t.cur])
+		s.Current = s.Current.Add(t.gap)
 		t.cur++
 		if t.cur >= len(s.ReplayOffsets) {
 			t.cur = 0
@@ -152,13 +176,17 @@ func (t *Timer) inc() {
 		s.Current = s.Current.Add(time.Duration(s.Interval) * time.Second)
 	}
 	if s.Wait {
-		timer := time.NewTimer(time.Duration(s.Interval) * time.Second)
-		<-timer.C
+		t.wait(time.Duration(s.Interval) * time.Second)
 	}
 }
 
-// Close shuts down a timer
+// Close shuts down a timer, the same as cancelling the context it was started with
 func (t *Timer) Close() {
 	log.Infof("Closing timer for sample %s", t.S.Name)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	t.closed = true
+	if t.cancel != nil {
+		t.cancel()
+	}
 }
@@ -1,6 +1,7 @@
 package timer
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -24,7 +25,7 @@ func TestTimer(t *testing.T) {
 	oq := make(chan *config.OutQueueItem)
 
 	timer := &Timer{S: s, GQ: gq, OQ: oq}
-	go timer.NewTimer(0)
+	go timer.NewTimer(context.Background(), 0)
 
 	item := <-gq
 
@@ -35,7 +36,7 @@ func TestTimer(t *testing.T) {
 	// Test that we're about the same interval
 	n := time.Now()
 	timer = &Timer{S: s, GQ: gq, OQ: oq}
-	go timer.NewTimer(0)
+	go timer.NewTimer(context.Background(), 0)
 	item = <-gq
 	cur := time.Now()
 
@@ -57,7 +58,7 @@ func TestRealtimeReplay(t *testing.T) {
 	gqs := make([]*config.GenQueueItem, 0, 10)
 
 	timer := &Timer{S: s, GQ: gq, OQ: oq, Done: done}
-	go timer.NewTimer(0)
+	go timer.NewTimer(context.Background(), 0)
 
 	time.Sleep(4 * time.Second)
 
@@ -88,7 +89,7 @@ func TestBackfill(t *testing.T) {
 	gqs := make([]*config.GenQueueItem, 0, 10)
 
 	timer := &Timer{S: s, GQ: gq, OQ: oq, Done: done}
-	go timer.NewTimer(0)
+	go timer.NewTimer(context.Background(), 0)
 	<-done
 Loop:
 	for {
@@ -116,7 +117,7 @@ func TestBackfillRealtime(t *testing.T) {
 	gqs := make([]*config.GenQueueItem, 0, 10)
 
 	timer := &Timer{S: s, GQ: gq, OQ: oq, Done: done}
-	go timer.NewTimer(0)
+	go timer.NewTimer(context.Background(), 0)
 
 	time.Sleep(2 * time.Second)
 Loop:
@@ -146,7 +147,7 @@ func TestBackfillFutureEnd(t *testing.T) {
 	gqs := make([]*config.GenQueueItem, 0, 10)
 
 	timer := &Timer{S: s, GQ: gq, OQ: oq, Done: done}
-	go timer.NewTimer(0)
+	go timer.NewTimer(context.Background(), 0)
 	<-done
 Loop:
 	for {
@@ -174,7 +175,7 @@ func TestCacheIntervals(t *testing.T) {
 	gqs := make([]*config.GenQueueItem, 0, 10)
 
 	timer := &Timer{S: s, GQ: gq, OQ: oq, Done: done}
-	go timer.NewTimer(2)
+	go timer.NewTimer(context.Background(), 2)
 	<-done
 Loop:
 	for {
@@ -217,7 +218,7 @@ func TestBackfillReplay(t *testing.T) {
 	gqs := make([]*config.GenQueueItem, 0, 10)
 
 	timer := &Timer{S: s, GQ: gq, OQ: oq, Done: done}
-	go timer.NewTimer(0)
+	go timer.NewTimer(context.Background(), 0)
 	<-done
 
 Loop:
@@ -247,17 +248,18 @@ func TestTimerClose(t *testing.T) {
 	gqs := make([]*config.GenQueueItem, 0, 10)
 
 	timer := &Timer{S: s, GQ: gq, OQ: oq, Done: done}
-	go timer.NewTimer(2)
+	go timer.NewTimer(context.Background(), 2)
 
 	// Let a few events generate
 	time.Sleep(100 * time.Millisecond)
 
-	// Close the timer
+	// Close the timer, which should stop it promptly
 	timer.Close()
-	close(done)
-
-	// Give it a moment to shut down
-	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timer did not stop after Close")
+	}
 
 Loop:
 	for {
@@ -273,3 +275,31 @@ Loop:
 	assert.Greater(t, len(gqs), 0)
 	assert.Less(t, len(gqs), 100) // Sanity check that timer actually stopped
 }
+
+func TestTimerCancel(t *testing.T) {
+	os.Setenv("GOGEN_HOME", "..")
+	os.Setenv("GOGEN_ALWAYS_REFRESH", "1")
+	home := filepath.Join("..", "tests", "timer")
+	os.Setenv("GOGEN_SAMPLES_DIR", home)
+
+	s := tests.FindSampleInFile(home, "backfillrealtime")
+
+	// Nothing reads the generator queue, so the timer is blocked queueing its first item
+	gq := make(chan *config.GenQueueItem)
+	oq := make(chan *config.OutQueueItem)
+	done := make(chan int)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := &Timer{S: s, GQ: gq, OQ: oq, Done: done}
+	go timer.NewTimer(ctx, 0)
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timer did not stop after cancel")
+	}
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}